@@ -0,0 +1,251 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
+
+/*
+AlarmEngine holds every reading against the safe range of its sensor, the one in the sensor table,
+see SensorCatalog.SafeRange, and keeps an alarm state per sensor:
+1, Normal, the reading is well inside the safe range
+2, Warning, the reading is within the warning margin of an edge of the safe range
+3, Alarm, the reading is outside of the safe range
+
+A sensor goes up to a state once its readings have been there for the configured delay, and back down
+once a reading is inside by the hysteresis, so a reading hovering at a limit doesn't make the alarm flap.
+Every transition is raised as AlarmChanged and published to AlarmsExchange, where PersistAlarmsQueue
+keeps it for the data manager. The engine goes by the timestamps of the readings, like the persistence policies.
+A lost sensor is resolved, back to Normal with its latest reading, and starts over from Normal once it's back.
+*/
+type AlarmEngine struct {
+	ea       *EventAggregator
+	broker   queueutils.Broker
+	pub      queueutils.Publisher // the broker itself, or a ReliablePublisher on top of it
+	producer *dto.Producer
+	catalog  *SensorCatalog
+	settings config.Alarms
+
+	mutex  sync.Mutex // the readings come in on the listener, lost sources on the SourceMonitor's goroutine
+	alarms map[string]*alarm
+}
+
+// alarm is the state of one sensor
+type alarm struct {
+	state dto.AlarmState
+	// since when the readings have been at least as severe as Warning and Alarm, zero while they aren't
+	since map[dto.AlarmState]time.Time
+
+	latest   EventData // the reading with the latest timestamp, a lost sensor is resolved with it
+	min, max float64   // the safe range it was held against
+}
+
+// NewAlarmEngine publishes the transitions with the codec for contentType, retryDelay is the one the data manager
+// declares PersistAlarmsQueue with, see queueutils.DeclareWorkQueue
+func NewAlarmEngine(ea *EventAggregator, broker queueutils.Broker, reliable bool, contentType string, retryDelay time.Duration,
+	settings config.Alarms, catalog *SensorCatalog) *AlarmEngine {
+	ae := AlarmEngine{
+		ea:       ea,
+		broker:   broker,
+		pub:      alarmPublisher(broker, reliable, retryDelay),
+		catalog:  catalog,
+		settings: settings,
+		alarms:   make(map[string]*alarm),
+	}
+
+	var err error
+	ae.producer, err = dto.NewProducer(dto.ProducerID("coordinator/alarms"), contentType)
+	if err != nil {
+		log.Fatalf("Failed to set up message encoding: %s", err)
+	}
+
+	// asynchronous and for every source at once, the readings of a sensor come in the order they were received
+	SubscribeAsync(ea, ReadingReceived.All(), ae.check, DefaultListenerQueueSize)
+	Subscribe(ea, SourceLost, ae.sourceLost)
+
+	return &ae
+}
+
+// sourceLost resolves a lost sensor and forgets its state, what it was in may well be over by the time it's back
+func (ae *AlarmEngine) sourceLost(src string) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	a := ae.alarms[src]
+	delete(ae.alarms, src)
+	if a == nil || a.state == dto.StateNormal {
+		return
+	}
+
+	t := dto.AlarmTransition{
+		Sensor:       src,
+		From:         a.state,
+		To:           dto.StateNormal,
+		Value:        a.latest.Value,
+		MinSafeValue: a.min,
+		MaxSafeValue: a.max,
+		Timestamp:    resolveTime(a.latest.Timestamp),
+	}
+
+	Publish(ae.ea, AlarmChanged, t)
+	ae.publish(t)
+}
+
+// resolveTime is when a resolve that no reading did happens: now, unless the clock of the sensor is ahead of ours,
+// then just after latest, the timestamp of the last reading or transition, so the alarm boards don't leave it out
+func resolveTime(latest time.Time) time.Time {
+	if now := time.Now(); now.After(latest) {
+		return now
+	}
+
+	return latest.Add(time.Nanosecond)
+}
+
+func (ae *AlarmEngine) check(ed EventData) {
+	min, max, ok := ae.catalog.SafeRange(ed.Name)
+	if !ok {
+		return
+	}
+
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	a := ae.alarms[ed.Name]
+	if a == nil {
+		a = &alarm{state: dto.StateNormal, since: make(map[dto.AlarmState]time.Time)}
+		ae.alarms[ed.Name] = a
+	}
+	if ed.Timestamp.After(a.latest.Timestamp) {
+		a.latest = ed
+	}
+	a.min, a.max = min, max
+
+	next := a.next(ed, min, max, ae.settings)
+	if next == a.state {
+		return
+	}
+
+	t := dto.AlarmTransition{
+		Sensor:       ed.Name,
+		From:         a.state,
+		To:           next,
+		Value:        ed.Value,
+		MinSafeValue: min,
+		MaxSafeValue: max,
+		Timestamp:    ed.Timestamp,
+	}
+	a.state = next
+
+	Publish(ae.ea, AlarmChanged, t)
+	ae.publish(t)
+}
+
+// alarmPublisher declares AlarmsExchange and PersistAlarmsQueue, and returns broker,
+// or a ReliablePublisher on top of it if reliable
+func alarmPublisher(broker queueutils.Broker, reliable bool, retryDelay time.Duration) queueutils.Publisher {
+	broker.DeclareExchange(queueutils.AlarmsExchange, queueutils.TopicExchange)
+
+	// the data manager declares it too, whoever comes first creates it, so no transition is lost in between
+	queueName := queueutils.GetWorkQueue(
+		queueutils.PersistAlarmsQueue, //name string,
+		broker,                        //broker queueutils.Broker,
+		retryDelay)                    //retryDelay time.Duration)
+	broker.BindQueue(
+		queueName,                 //queue string,
+		"#",                       //key string,
+		queueutils.AlarmsExchange) //exchange string)
+
+	if !reliable {
+		return broker
+	}
+
+	pub, err := queueutils.NewReliablePublisher(broker, queueutils.DefaultPublishBufferSize)
+	if err != nil {
+		log.Fatalf("Failed to set up reliable publishing: %s", err)
+	}
+	return pub
+}
+
+// next returns the state the sensor is in after ed
+func (a *alarm) next(ed EventData, min, max float64, settings config.Alarms) dto.AlarmState {
+	width := max - min
+	margin := settings.WarningMargin * width
+
+	reached := alarmLevel(ed.Value, min, max, margin)
+	for _, s := range []dto.AlarmState{dto.StateWarning, dto.StateAlarm} {
+		switch {
+		case reached.Severity() < s.Severity():
+			delete(a.since, s)
+		case a.since[s].IsZero():
+			a.since[s] = ed.Timestamp
+		}
+	}
+
+	// up, to the most severe state that has lasted long enough
+	for _, s := range []dto.AlarmState{dto.StateAlarm, dto.StateWarning} {
+		if s.Severity() <= a.state.Severity() {
+			break
+		}
+		if since, ok := a.since[s]; ok && ed.Timestamp.Sub(since) >= settings.Delay {
+			return s
+		}
+	}
+
+	// down, as far as the reading is inside by the hysteresis
+	if reached.Severity() < a.state.Severity() {
+		shift := settings.Hysteresis * width
+		if down := alarmLevel(ed.Value, min+shift, max-shift, margin); down.Severity() < a.state.Severity() {
+			return down
+		}
+	}
+
+	return a.state
+}
+
+// alarmLevel returns the state of a reading of value against the safe range from min to max
+func alarmLevel(value, min, max, margin float64) dto.AlarmState {
+	switch {
+	case value < min || value > max:
+		return dto.StateAlarm
+	case value < min+margin || value > max-margin:
+		return dto.StateWarning
+	}
+
+	return dto.StateNormal
+}
+
+func (ae *AlarmEngine) publish(t dto.AlarmTransition) {
+	env, err := ae.producer.WrapAlarmTransition(t)
+	if err != nil {
+		fmt.Printf("Failed to encode alarm of %v: %s\n", t.Sensor, err)
+		return
+	}
+
+	err = ae.pub.Publish(
+		queueutils.AlarmsExchange,    //exchange string,
+		t.Alarm(),                    //key string,
+		queueutils.ToPublishing(env)) //msg amqp.Publishing)
+
+	if err != nil {
+		fmt.Printf("Failed to publish alarm of %v: %s\n", t.Sensor, err)
+	}
+}
+
+// Close waits until the transitions are with the broker or ctx is done, and closes the broker,
+// the readings have to have stopped coming in
+func (ae *AlarmEngine) Close(ctx context.Context) error {
+	var err error
+	if pub, ok := ae.pub.(*queueutils.ReliablePublisher); ok {
+		err = pub.Close(ctx)
+	}
+
+	ae.broker.Close()
+	return err
+}
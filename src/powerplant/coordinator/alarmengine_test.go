@@ -0,0 +1,103 @@
+package coordinator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+)
+
+func TestAlarmLevel(t *testing.T) {
+	// safe from 0 to 10, warning within 1 of an edge
+	for _, c := range []struct {
+		value float64
+		want  dto.AlarmState
+	}{
+		{-0.1, dto.StateAlarm},
+		{0, dto.StateWarning},
+		{0.9, dto.StateWarning},
+		{1, dto.StateNormal},
+		{5, dto.StateNormal},
+		{9, dto.StateNormal},
+		{9.1, dto.StateWarning},
+		{10, dto.StateWarning},
+		{10.1, dto.StateAlarm},
+	} {
+		if got := alarmLevel(c.value, 0, 10, 1); got != c.want {
+			t.Errorf("%g: expected %s, got %s", c.value, c.want, got)
+		}
+	}
+}
+
+// alarmStep is a reading of the sensor at second at, and the state the sensor is expected to be in after it
+type alarmStep struct {
+	at    int
+	value float64
+	want  dto.AlarmState
+}
+
+func TestAlarmNext(t *testing.T) {
+	// safe from 0 to 10: warning within 1 of an edge, back down once inside by 0.2 more
+	immediate := config.Alarms{WarningMargin: 0.1, Hysteresis: 0.02}
+	delayed := config.Alarms{WarningMargin: 0.1, Hysteresis: 0.02, Delay: 5 * time.Second}
+
+	for _, c := range []struct {
+		name     string
+		settings config.Alarms
+		readings []alarmStep
+	}{
+		{"hysteresis", immediate, []alarmStep{
+			{0, 9.5, dto.StateWarning},
+			{1, 8.9, dto.StateWarning}, // inside, but not by the hysteresis
+			{2, 8.7, dto.StateNormal},
+			{3, 0.5, dto.StateWarning},
+			{4, 1.1, dto.StateWarning},
+			{5, 1.3, dto.StateNormal},
+		}},
+		{"delay", delayed, []alarmStep{
+			{0, 10.5, dto.StateNormal},
+			{3, 10.5, dto.StateNormal},
+			{4, 5, dto.StateNormal}, // starts the delay over
+			{5, 10.5, dto.StateNormal},
+			{9, 10.5, dto.StateNormal},
+			{10, 10.5, dto.StateAlarm},
+		}},
+		{"escalation", delayed, []alarmStep{
+			{0, 9.5, dto.StateNormal},
+			{5, 9.5, dto.StateWarning},
+			{6, 10.5, dto.StateWarning}, // an Alarm reading keeps the Warning going too
+			{10, 10.5, dto.StateWarning},
+			{11, 10.5, dto.StateAlarm},
+		}},
+		{"straight to alarm", delayed, []alarmStep{
+			{0, -1, dto.StateNormal},
+			{5, -1, dto.StateAlarm}, // Warning has lasted as long, the most severe one wins
+		}},
+		{"de-escalation", immediate, []alarmStep{
+			{0, 10.5, dto.StateAlarm},
+			{1, 9.9, dto.StateAlarm}, // inside, but not by the hysteresis
+			{2, 9.7, dto.StateWarning},
+			{3, 5, dto.StateNormal},
+		}},
+		{"down without the delay", delayed, []alarmStep{
+			{0, 10.5, dto.StateNormal},
+			{5, 10.5, dto.StateAlarm},
+			{6, 5, dto.StateNormal},
+			{7, 10.5, dto.StateNormal},
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			a := &alarm{state: dto.StateNormal, since: make(map[dto.AlarmState]time.Time)}
+
+			for _, r := range c.readings {
+				ed := EventData{Name: "boiler_pressure_out", Value: r.value, Timestamp: start.Add(time.Duration(r.at) * time.Second)}
+				a.state = a.next(ed, 0, 10, c.settings)
+				if a.state != r.want {
+					t.Fatalf("%g at %ds: expected %s, got %s", r.value, r.at, r.want, a.state)
+				}
+			}
+		})
+	}
+}
@@ -1,96 +1,193 @@
 package coordinator
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"fmt"
+	"log"
+	"sync"
 	"time"
 
+	"github.com/golang-distributed-application/src/powerplant/config"
 	"github.com/golang-distributed-application/src/powerplant/dto"
 	"github.com/golang-distributed-application/src/powerplant/queueutils"
-	"github.com/streadway/amqp"
 )
 
-const maxRate = 5 * time.Second
-
 // !!! listening the events and determine which ones to forward to databse manager to persist the data
 type DatabaseConsumer struct {
-	er      EventRaiser // so DatabaseConsumer itself doesn't have to know how to publish event
-	conn    *amqp.Connection
-	ch      *amqp.Channel
-	queue   *amqp.Queue // it's used to route the message to when DatabaseConsumer decides to persist in db
-	sources []string
+	er       EventRaiser // so DatabaseConsumer itself doesn't have to know how to publish event
+	broker   queueutils.Broker
+	queue    string               // it's used to route the message to when DatabaseConsumer decides to persist in db
+	pub      queueutils.Publisher // the broker itself, or a ReliablePublisher on top of it
+	producer *dto.Producer
+
+	policies config.Persistence
+	catalog  *SensorCatalog // the safe ranges of the sensors
+
+	mutex   sync.Mutex                  // sources are discovered and lost on different goroutines
+	sources map[string]*persistedSource // the listener of each source's readings
+}
+
+// persistedSource is the policy of a source together with the listener feeding it
+type persistedSource struct {
+	sub *Subscription
+
+	mutex  sync.Mutex // the listener may still be running when the source is lost
+	policy PersistencePolicy
+	closed bool
 }
 
-func NewDatabaseConsumer(er EventRaiser) *DatabaseConsumer {
+// NewDatabaseConsumer encodes the readings to persist with the codec for contentType, see dto.CodecFor,
+// retryDelay is the one the data manager declares the queue with, see queueutils.DeclareWorkQueue.
+// Each source gets its own policy from policies, the safe ranges of the sensors come from catalog.
+func NewDatabaseConsumer(er EventRaiser, broker queueutils.Broker, reliable bool, contentType string, retryDelay time.Duration,
+	policies config.Persistence, catalog *SensorCatalog) *DatabaseConsumer {
 	dc := DatabaseConsumer{
-		er: er,
+		er:       er,
+		broker:   broker,
+		pub:      broker,
+		policies: policies,
+		catalog:  catalog,
+		sources:  make(map[string]*persistedSource),
 	}
-	dc.conn, dc.ch = queueutils.GetChannel(url)
-	dc.queue = queueutils.GetQueue(
+	dc.queue = queueutils.GetWorkQueue(
 		queueutils.PersistReadingsQueue, //name string,
-		dc.ch, //ch *amqp.Channel,
-		false) //autoDelete bool)
+		dc.broker,  //broker queueutils.Broker,
+		retryDelay) //retryDelay time.Duration)
 
-	dc.er.AddListener(queueutils.DataSourceDiscoveredEvent,
-		// anonymous function
-		func(eventData interface{}) {
-			dc.SubscribeToDataEvent(eventData.(string))
-		})
+	var err error
+	dc.producer, err = dto.NewProducer(dto.ProducerID("coordinator/database"), contentType)
+	if err != nil {
+		log.Fatalf("Failed to set up message encoding: %s", err)
+	}
+
+	if reliable {
+		pub, err := queueutils.NewReliablePublisher(dc.broker, queueutils.DefaultPublishBufferSize)
+		if err != nil {
+			log.Fatalf("Failed to set up reliable publishing: %s", err)
+		}
+		dc.pub = pub
+	}
+
+	Subscribe(dc.er, SourceDiscovered, dc.SubscribeToDataEvent)
+	Subscribe(dc.er, SourceLost, dc.UnsubscribeFromDataEvent)
 
 	return &dc
 }
 
 func (dc *DatabaseConsumer) SubscribeToDataEvent(eventName string) {
-	for _, v := range dc.sources {
-		if v == eventName {
-			// existing data source
-			return
-		}
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	if dc.sources[eventName] != nil {
+		// existing data source
+		return
+	}
+
+	// !!! a policy of its own for every source, it keeps what it needs from one reading to the next,
+	// e.g. when it last persisted one
+	policy, err := NewPersistencePolicy(dc.policies.Policy(eventName), dc.safeRange(eventName))
+	if err != nil {
+		fmt.Printf("Not persisting the readings of %v: %s\n", eventName, err)
+		return
 	}
 
-	// !!! callback is a self-executing function that will return the callback itself
-	// so a new isolated variable scope will be created every time i call this function
-	// it's a trick to allow the event handler to register a bit of state that
-	//  we'll need to throttle down the rate that the messages are coming in from the event sources.
-	// here we throttle the messages to be persisted no faster than once every five seconds.
-	dc.er.AddListener(queueutils.MessageReceivedEvent+eventName,
-		func() func(interface{}) {
-			prevTime := time.Unix(0, 0) // initial value, 45 years ago
-
-			buf := new(bytes.Buffer)
-
-			// !!! use a closure here since variables created in it are only visible to other members of the closure,
-			// it means that prevTime and buf variables are going to be accessible by the returned callback function,
-			// BUT they will retain their states from call to call since their states are captured by the closure, not the callback
-			return func(eventData interface{}) {
-				ed := eventData.(EventData)
-				if time.Since(prevTime) > maxRate {
-					prevTime = time.Now() // reset the prevTime
-
-					// create a sensor message to publish
-					sensorMsg := dto.SensorMessage{
-						Name:      ed.Name,
-						Value:     ed.Value,
-						Timestamp: ed.Timestamp,
-					}
-
-					buf.Reset()
-
-					encoder := gob.NewEncoder(buf)
-					encoder.Encode(sensorMsg)
-
-					// publishing
-					msg := amqp.Publishing{
-						Body: buf.Bytes(),
-					}
-
-					dc.ch.Publish(
-						"", //exchange string,
-						queueutils.PersistReadingsQueue, //key string,
-						false, //mandatory bool,
-						false, //immediate bool,
-						msg)   //msg amqp.Publishing)
-				}
+	src := &persistedSource{policy: policy}
+
+	// asynchronous, so a slow publish of the readings to persist doesn't hold up the web applications
+	src.sub = SubscribeAsync(dc.er, ReadingReceived.For(eventName),
+		func(ed EventData) {
+			src.mutex.Lock()
+			defer src.mutex.Unlock()
+
+			if src.closed {
+				return
+			}
+
+			for _, r := range src.policy.Offer(ed) {
+				dc.persist(r)
 			}
-		}())
+		},
+		DefaultListenerQueueSize)
+
+	dc.sources[eventName] = src
+}
+
+// safeRange returns the safe range of a sensor, the one of the sensor table, see SensorCatalog.SafeRange
+func (dc *DatabaseConsumer) safeRange(name string) SafeRange {
+	return func() (float64, float64, bool) {
+		return dc.catalog.SafeRange(name)
+	}
+}
+
+func (dc *DatabaseConsumer) persist(ed EventData) {
+	// create a sensor message to publish
+	sensorMsg := dto.SensorMessage{
+		Name:      ed.Name,
+		Value:     ed.Value,
+		Timestamp: ed.Timestamp,
+	}
+
+	env, err := dc.producer.WrapSensorMessage(sensorMsg)
+	if err != nil {
+		fmt.Printf("Failed to encode reading of %v to persist: %s\n", ed.Name, err)
+		return
+	}
+
+	// publishing
+	msg := queueutils.ToPublishing(env)
+
+	err = dc.pub.Publish(
+		"",       //exchange string,
+		dc.queue, //key string,
+		msg)      //msg amqp.Publishing)
+
+	if err != nil {
+		fmt.Printf("Failed to publish reading of %v to persist: %s\n", ed.Name, err)
+	}
+}
+
+// UnsubscribeFromDataEvent stops persisting the readings of a lost source, after what its policy has held back,
+// it starts over with a new policy when the source is discovered again
+func (dc *DatabaseConsumer) UnsubscribeFromDataEvent(eventName string) {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	if src := dc.sources[eventName]; src != nil {
+		src.sub.Unsubscribe()
+		dc.flush(src)
+		delete(dc.sources, eventName)
+	}
+}
+
+// flush persists what the policy of src has held back, nothing comes after it
+func (dc *DatabaseConsumer) flush(src *persistedSource) {
+	src.mutex.Lock()
+	defer src.mutex.Unlock()
+
+	if src.closed {
+		return
+	}
+	src.closed = true
+
+	for _, r := range src.policy.Flush() {
+		dc.persist(r)
+	}
+}
+
+// Close persists what the policies have held back, once the readings have stopped coming in,
+// waits until the readings to persist are with the broker or ctx is done, and closes the broker
+func (dc *DatabaseConsumer) Close(ctx context.Context) error {
+	dc.mutex.Lock()
+	for _, src := range dc.sources {
+		dc.flush(src)
+	}
+	dc.mutex.Unlock()
+
+	var err error
+	if pub, ok := dc.pub.(*queueutils.ReliablePublisher); ok {
+		err = pub.Close(ctx)
+	}
+
+	dc.broker.Close()
+	return err
 }
@@ -1,18 +1,38 @@
 package coordinator
 
-import "time"
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
 
 // EventRaiser will be used in the consumer, so the consumer itself doesn't have to know how to publish the event
 type EventRaiser interface {
-	AddListener(eventName string, f func(interface{})) // change the parameter to generic type, too.
+	AddListener(eventName string, f func(interface{})) *Subscription // see Subscribe for typed payloads
+	AddAsyncListener(eventName string, f func(interface{}), queueSize int) *Subscription
 }
 
 // EventAggregator lets consumers use AddListener to register an event, then it'll loop through all the events and
-// trigger the callback function for each registered consumer
+// trigger the callback function for each registered consumer.
+// It's safe to use from many goroutines, sources are discovered and readings are published concurrently.
+// Event names are dot separated words, a listener can register a pattern like a binding key of
+// a topic exchange instead, "reading.*" gets the readings of every source.
 type EventAggregator struct {
-	listeners map[string][]func(interface{}) // map's value is all the callbacks for all registered consumers for this specific event
+	mutex sync.RWMutex
+	// map's value is all the subscriptions of the registered consumers for this specific event,
+	// !!! a slice is never changed once it's in the map, a new one replaces it, so PublishEvent can loop over it without the lock
+	listeners map[string][]*Subscription
+	patterns  []*Subscription // same as above, for the listeners of "*" and "#" patterns
 }
 
+// DefaultListenerQueueSize is how many events an asynchronous listener of the coordinator can fall behind,
+// a few minutes of readings at the sensors' default rate
+const DefaultListenerQueueSize = 1000
+
 type EventData struct {
 	Name      string
 	Value     float64
@@ -21,23 +41,207 @@ type EventData struct {
 
 func NewEventAggregator() *EventAggregator {
 	ea := EventAggregator{
-		listeners: make(map[string][]func(interface{})),
+		listeners: make(map[string][]*Subscription),
 	}
 
 	return &ea
 }
 
-// AddListener lets a consumer register event(name) with its callback function(callback)
-func (ea *EventAggregator) AddListener(name string, callback func(interface{})) {
-	ea.listeners[name] = append(ea.listeners[name], callback)
+// Subscription is a listener registered with the EventAggregator, it's used to remove the listener again
+type Subscription struct {
+	dropped uint64 // first, so it's 64-bit aligned for the atomic operations on 32-bit platforms
+
+	ea       *EventAggregator
+	name     string
+	callback func(interface{})
+
+	queue    chan interface{} // nil for listeners called by PublishEvent itself
+	done     chan struct{}    // closed by Unsubscribe
+	once     sync.Once
+	drain    chan struct{} // closed by EventAggregator.Close
+	drained  chan struct{} // closed when run has stopped
+	drainOne sync.Once
+}
+
+// AddListener lets a consumer register event(name) with its callback function(callback),
+// the callback is called by PublishEvent itself, so it holds up the publisher and the other listeners while it runs
+func (ea *EventAggregator) AddListener(name string, callback func(interface{})) *Subscription {
+	sub := &Subscription{
+		ea:       ea,
+		name:     name,
+		callback: callback,
+		done:     make(chan struct{}),
+	}
+
+	ea.add(sub)
+	return sub
+}
+
+// AddAsyncListener registers a callback that runs on a goroutine of its own, fed by a queue of queueSize events,
+// so a slow consumer (e.g. one publishing to the database) can't stall the others.
+// When the queue is full the event is dropped for this listener only, see Subscription.Dropped.
+func (ea *EventAggregator) AddAsyncListener(name string, callback func(interface{}), queueSize int) *Subscription {
+	sub := &Subscription{
+		ea:       ea,
+		name:     name,
+		callback: callback,
+		queue:    make(chan interface{}, queueSize),
+		done:     make(chan struct{}),
+		drain:    make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+
+	go sub.run()
+
+	ea.add(sub)
+	return sub
+}
+
+func (ea *EventAggregator) add(sub *Subscription) {
+	ea.mutex.Lock()
+	defer ea.mutex.Unlock()
+
+	if isPattern(sub.name) {
+		ea.patterns = append(ea.patterns[:len(ea.patterns):len(ea.patterns)], sub)
+		return
+	}
+
+	subs := ea.listeners[sub.name]
+	ea.listeners[sub.name] = append(subs[:len(subs):len(subs)], sub)
+}
+
+func (ea *EventAggregator) remove(sub *Subscription) {
+	ea.mutex.Lock()
+	defer ea.mutex.Unlock()
+
+	if isPattern(sub.name) {
+		ea.patterns = without(ea.patterns, sub)
+		return
+	}
+
+	kept := without(ea.listeners[sub.name], sub)
+	if len(kept) == 0 {
+		delete(ea.listeners, sub.name)
+	} else {
+		ea.listeners[sub.name] = kept
+	}
+}
+
+func without(subs []*Subscription, sub *Subscription) []*Subscription {
+	kept := make([]*Subscription, 0, len(subs))
+	for _, s := range subs {
+		if s != sub {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*#")
 }
 
 // PublishEvent loops all the registered consumers' callbacks and trigger the same event for each of them
 // NOTE: eventData is passed by value since it should be mutable.
 func (ea *EventAggregator) PublishEvent(name string, eventData interface{}) {
-	if ea.listeners[name] != nil {
-		for _, callback := range ea.listeners[name] {
-			callback(eventData)
+	ea.mutex.RLock()
+	subs := ea.listeners[name]
+	patterns := ea.patterns
+	ea.mutex.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(eventData)
+	}
+
+	for _, sub := range patterns {
+		if queueutils.TopicMatches(sub.name, name) {
+			sub.deliver(eventData)
+		}
+	}
+}
+
+// Close removes all the listeners, the asynchronous ones get to handle the events in their queues first,
+// Close returns once they have
+func (ea *EventAggregator) Close() {
+	ea.mutex.Lock()
+	subs := ea.patterns
+	for _, s := range ea.listeners {
+		subs = append(subs[:len(subs):len(subs)], s...)
+	}
+	ea.listeners = make(map[string][]*Subscription)
+	ea.patterns = nil
+	ea.mutex.Unlock()
+
+	for _, sub := range subs {
+		if sub.queue != nil {
+			sub.drainOne.Do(func() { close(sub.drain) })
+			<-sub.drained
+		}
+	}
+}
+
+// Unsubscribe removes the listener, an asynchronous one drops the events still in its queue.
+// The callback may still be running when Unsubscribe returns, but it won't be called again.
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.ea.remove(sub)
+		close(sub.done)
+	})
+}
+
+// Dropped returns how many events an asynchronous listener has missed because its queue was full
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+func (sub *Subscription) deliver(eventData interface{}) {
+	// PublishEvent may have picked the listener up just before it unsubscribed
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+
+	if sub.queue == nil {
+		sub.callback(eventData)
+		return
+	}
+
+	select {
+	case sub.queue <- eventData:
+	default:
+		// !!! report the first drop and then every 100th, a listener that's stuck would otherwise flood the log
+		if dropped := atomic.AddUint64(&sub.dropped, 1); dropped%100 == 1 {
+			log.Printf("Listener of %s is falling behind, %d events dropped so far\n", sub.name, dropped)
+		}
+	}
+}
+
+func (sub *Subscription) run() {
+	defer close(sub.drained)
+
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.drain:
+			// only what's queued already, PublishEvent doesn't find the listener any more
+			for {
+				select {
+				case eventData := <-sub.queue:
+					sub.callback(eventData)
+				default:
+					return
+				}
+			}
+		case eventData := <-sub.queue:
+			select {
+			case <-sub.done:
+				return
+			default:
+				sub.callback(eventData)
+			}
 		}
 	}
 }
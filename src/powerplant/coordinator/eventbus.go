@@ -0,0 +1,89 @@
+package coordinator
+
+import (
+	"log"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+)
+
+/*
+Topics give the events of the coordinator their payload types, so a listener gets a string or an EventData
+instead of an interface{} it has to type-assert, and a mismatch doesn't compile instead of panicking.
+The EventAggregator underneath still carries interface{}, Publish and Subscribe are the only ones
+putting events in and taking them out, so the payload always has the type of its topic.
+*/
+
+// Topic is an event name together with the type of its payload
+type Topic[T any] struct {
+	name string
+}
+
+// NewTopic returns the topic for events named name, dot separated words like "source.discovered"
+func NewTopic[T any](name string) Topic[T] {
+	return Topic[T]{name: name}
+}
+
+// Name returns the event name of the topic
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// For returns the subtopic for one key, usually a source: ReadingReceived.For("boiler") is "reading.boiler"
+func (t Topic[T]) For(key string) Topic[T] {
+	return Topic[T]{name: t.name + "." + key}
+}
+
+// All returns the pattern matching every subtopic of For, e.g. "reading.*",
+// !!! "*" is one word only, so keys mustn't have dots in them, see dto.CheckSensorName
+func (t Topic[T]) All() Topic[T] {
+	return t.For("*")
+}
+
+// the events of the coordinator
+var (
+	// SourceDiscovered is raised with the name of a sensor whenever it announces itself
+	SourceDiscovered = NewTopic[string]("source.discovered")
+	// ReadingReceived is raised under ReadingReceived.For(sensor name) for every reading
+	ReadingReceived = NewTopic[EventData]("reading")
+	// SourceLost is raised with the name of a sensor that stopped sending readings, see SourceMonitor
+	SourceLost = NewTopic[string]("source.lost")
+	// SourceRecovered is raised with the name of a lost sensor that is sending readings again
+	SourceRecovered = NewTopic[string]("source.recovered")
+	// SensorInfoChanged is raised with the heartbeat of a sensor that showed up or changed its setup, see SensorCatalog
+	SensorInfoChanged = NewTopic[dto.Heartbeat]("source.info")
+	// AlarmChanged is raised with every change of the alarm state of a sensor or a rule, see AlarmEngine and RuleEngine
+	AlarmChanged = NewTopic[dto.AlarmTransition]("alarm.changed")
+	// RuleChanged is raised with the state of a rule whenever it's loaded, fires or resolves, see RuleEngine
+	RuleChanged = NewTopic[dto.RuleState]("rule.changed")
+	// AggregateComputed is raised under AggregateComputed.For(window name).For(sensor name) for every window closed,
+	// AggregateComputed.For("10s").All() gets the 10s aggregates of every sensor, see WindowAggregator
+	AggregateComputed = NewTopic[dto.Aggregate]("aggregate")
+)
+
+// Publish raises an event of the topic, topic mustn't be a pattern
+func Publish[T any](ea *EventAggregator, topic Topic[T], data T) {
+	ea.PublishEvent(topic.name, data)
+}
+
+// Subscribe registers a listener that's called by the publisher itself, see EventAggregator.AddListener
+func Subscribe[T any](er EventRaiser, topic Topic[T], f func(T)) *Subscription {
+	return er.AddListener(topic.name, typed(topic, f))
+}
+
+// SubscribeAsync registers a listener with a queue of its own, see EventAggregator.AddAsyncListener
+func SubscribeAsync[T any](er EventRaiser, topic Topic[T], f func(T), queueSize int) *Subscription {
+	return er.AddAsyncListener(topic.name, typed(topic, f), queueSize)
+}
+
+func typed[T any](topic Topic[T], f func(T)) func(interface{}) {
+	return func(eventData interface{}) {
+		// only when someone calls PublishEvent directly with the wrong type, or a pattern covers other topics
+		data, ok := eventData.(T)
+		if !ok {
+			log.Printf("Ignoring event for %s with unexpected payload %T\n", topic.name, eventData)
+			return
+		}
+
+		f(data)
+	}
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/golang-distributed-application/src/powerplant/config"
 	"github.com/golang-distributed-application/src/powerplant/coordinator"
 )
 
 func main() {
-	coordinator.StartConsumingSensorData()
+	cfg := config.MustLoad()
 
-	var pause string
-	fmt.Scanln(&pause)
+	// runs until it's interrupted or terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := coordinator.StartConsumingSensorData(ctx, cfg); err != nil {
+		log.Fatalf("Coordinator stopped: %s", err)
+	}
+
+	log.Println("Coordinator stopped")
 }
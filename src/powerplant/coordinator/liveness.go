@@ -0,0 +1,105 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+SourceMonitor notices sensors that have gone quiet: a source that hasn't sent a reading for the timeout
+is lost, SourceLost is raised and the rest of the coordinator forgets about it. SourceRecovered is raised
+with the first reading after that, which only arrives once the sensor has announced itself again and
+the QueuesListener consumes its queue anew, so while any source is lost the monitor keeps asking the
+sensors to announce themselves, a sensor that was only cut off from the broker for a while comes back, too.
+*/
+type SourceMonitor struct {
+	ea      *EventAggregator
+	timeout time.Duration
+
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time // sources that are alive
+	lost     map[string]bool      // !!! kept forever, so a returning sensor is recovered and not just discovered
+}
+
+func NewSourceMonitor(ea *EventAggregator, timeout time.Duration) *SourceMonitor {
+	sm := SourceMonitor{
+		ea:       ea,
+		timeout:  timeout,
+		lastSeen: make(map[string]time.Time),
+		lost:     make(map[string]bool),
+	}
+
+	Subscribe(ea, SourceDiscovered, sm.discovered)
+	Subscribe(ea, ReadingReceived.All(), sm.seen)
+
+	return &sm
+}
+
+// a sensor that announces itself but never sends a reading is lost after the timeout, too
+func (sm *SourceMonitor) discovered(name string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if _, ok := sm.lastSeen[name]; !ok && !sm.lost[name] {
+		sm.lastSeen[name] = time.Now()
+	}
+}
+
+func (sm *SourceMonitor) seen(ed EventData) {
+	sm.mutex.Lock()
+	recovered := sm.lost[ed.Name]
+	delete(sm.lost, ed.Name)
+	sm.lastSeen[ed.Name] = time.Now()
+	sm.mutex.Unlock()
+
+	if recovered {
+		fmt.Printf("Source %v recovered\n", ed.Name)
+		Publish(sm.ea, SourceRecovered, ed.Name)
+	}
+}
+
+// Run checks the sources until ctx is done, rediscover asks the sensors to announce themselves
+func (sm *SourceMonitor) Run(ctx context.Context, rediscover func()) {
+	lastRediscovery := time.Now()
+
+	ticker := time.NewTicker(sm.timeout / 5)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lost, anyLost := sm.expire(time.Now())
+
+		for _, name := range lost {
+			fmt.Printf("Source %v lost, no readings for %v\n", name, sm.timeout)
+			Publish(sm.ea, SourceLost, name)
+		}
+
+		if anyLost && time.Since(lastRediscovery) >= sm.timeout {
+			lastRediscovery = time.Now()
+			rediscover()
+		}
+	}
+}
+
+// expire moves the sources that have been quiet for too long to the lost ones
+func (sm *SourceMonitor) expire(now time.Time) (lost []string, anyLost bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for name, seen := range sm.lastSeen {
+		if now.Sub(seen) > sm.timeout {
+			delete(sm.lastSeen, name)
+			sm.lost[name] = true
+			lost = append(lost, name)
+		}
+	}
+
+	return lost, len(sm.lost) > 0
+}
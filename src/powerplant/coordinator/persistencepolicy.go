@@ -0,0 +1,174 @@
+package coordinator
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+)
+
+/*
+PersistencePolicy decides which readings of a sensor the DatabaseConsumer passes on to the data manager.
+It's given the readings of its sensor one at a time, in the order they come in, and keeps whatever it needs
+from one to the next, so every sensor has a policy of its own. The policies go by the timestamps of the readings,
+not by the clock of the coordinator.
+*/
+type PersistencePolicy interface {
+	// Offer returns what to persist now that ed has come in: nothing, ed or what the policy has made of earlier readings
+	Offer(ed EventData) []EventData
+	// Flush returns what the policy is holding back, once the sensor is lost or the coordinator stops
+	Flush() []EventData
+}
+
+// SafeRange returns the safe range of a sensor, ok is false as long as it isn't known
+type SafeRange func() (min, max float64, ok bool)
+
+// NewPersistencePolicy builds the policy cfg describes, safeRange is only asked with cfg.OutsideSafeRange
+func NewPersistencePolicy(cfg config.PersistencePolicy, safeRange SafeRange) (PersistencePolicy, error) {
+	var policy PersistencePolicy
+
+	switch cfg.Policy {
+	case config.IntervalPolicy:
+		policy = &intervalPolicy{interval: cfg.Interval}
+	case config.DeadbandPolicy:
+		policy = &deadbandPolicy{deadband: cfg.Deadband, maxInterval: cfg.MaxInterval}
+	case config.WindowPolicy:
+		fold, err := windowFold(cfg.Agg)
+		if err != nil {
+			return nil, err
+		}
+		policy = &windowPolicy{window: cfg.Window, fold: fold}
+	default:
+		return nil, fmt.Errorf("unknown persistence policy '%s'", cfg.Policy)
+	}
+
+	if cfg.OutsideSafeRange {
+		policy = &safeRangePolicy{PersistencePolicy: policy, safeRange: safeRange}
+	}
+
+	return policy, nil
+}
+
+// intervalPolicy persists a reading and then nothing for interval, 0 persists every reading
+type intervalPolicy struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func (p *intervalPolicy) Offer(ed EventData) []EventData {
+	if !p.last.IsZero() && ed.Timestamp.Sub(p.last) < p.interval {
+		return nil
+	}
+
+	p.last = ed.Timestamp
+	return []EventData{ed}
+}
+
+func (p *intervalPolicy) Flush() []EventData {
+	return nil
+}
+
+// deadbandPolicy persists a reading when it has moved more than deadband from the last one persisted,
+// or maxInterval after it if that's set, so a steady sensor still shows up now and then
+type deadbandPolicy struct {
+	deadband    float64
+	maxInterval time.Duration
+	last        *EventData
+}
+
+func (p *deadbandPolicy) Offer(ed EventData) []EventData {
+	if p.last != nil && math.Abs(ed.Value-p.last.Value) <= p.deadband &&
+		(p.maxInterval <= 0 || ed.Timestamp.Sub(p.last.Timestamp) < p.maxInterval) {
+		return nil
+	}
+
+	p.last = &ed
+	return []EventData{ed}
+}
+
+func (p *deadbandPolicy) Flush() []EventData {
+	return nil
+}
+
+// windowPolicy persists a reading per window, made of the readings taken in it by fold and timestamped
+// with the start of the window, it's persisted once a reading of a later window comes in
+type windowPolicy struct {
+	window time.Duration
+	fold   func(acc, value float64, count int) float64
+
+	start time.Time
+	value float64
+	count int
+	name  string
+}
+
+func (p *windowPolicy) Offer(ed EventData) []EventData {
+	var done []EventData
+
+	start := ed.Timestamp.Truncate(p.window)
+	if p.count > 0 && !start.Equal(p.start) {
+		done = p.Flush()
+	}
+
+	if p.count == 0 {
+		p.start, p.value, p.name = start, ed.Value, ed.Name
+	}
+	p.count++
+	p.value = p.fold(p.value, ed.Value, p.count)
+
+	return done
+}
+
+func (p *windowPolicy) Flush() []EventData {
+	if p.count == 0 {
+		return nil
+	}
+
+	ed := EventData{Name: p.name, Value: p.value, Timestamp: p.start}
+	p.count = 0
+	return []EventData{ed}
+}
+
+// windowFold returns how a window folds its readings, value is the count-th one and acc what the earlier ones made
+func windowFold(agg string) (func(acc, value float64, count int) float64, error) {
+	switch agg {
+	case config.AvgAgg, "":
+		return func(acc, value float64, count int) float64 {
+			return acc + (value-acc)/float64(count)
+		}, nil
+	case config.MinAgg:
+		return func(acc, value float64, count int) float64 {
+			return math.Min(acc, value)
+		}, nil
+	case config.MaxAgg:
+		return func(acc, value float64, count int) float64 {
+			return math.Max(acc, value)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown window aggregation '%s'", agg)
+}
+
+// safeRangePolicy persists every reading outside the safe range, and whatever the policy underneath picks
+type safeRangePolicy struct {
+	PersistencePolicy
+	safeRange SafeRange
+}
+
+func (p *safeRangePolicy) Offer(ed EventData) []EventData {
+	picked := p.PersistencePolicy.Offer(ed)
+
+	min, max, ok := p.safeRange()
+	if !ok || (ed.Value >= min && ed.Value <= max) {
+		return picked
+	}
+
+	for _, r := range picked {
+		if r == ed {
+			return picked
+		}
+	}
+
+	return append(picked, ed)
+}
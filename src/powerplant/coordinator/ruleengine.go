@@ -0,0 +1,277 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/coordinator/rules"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
+
+/*
+RuleEngine evaluates the rules of the rules file, conditions across sensors written in the language of
+the rules package, with every reading of the sensors they are about. A rule fires with its severity while
+its condition holds and resolves once it doesn't:
+1, every transition is raised as AlarmChanged and published to AlarmsExchange under "rule.<name>", with the rule in it
+2, every change of a rule, and every rule when the file is loaded, is raised as RuleChanged for the web applications
+3, Run looks for changes of the file, a rule keeps its state unless its condition or severity has changed
+
+A rule that's removed or changed while it fires is resolved, a file that doesn't load leaves the rules as they were.
+A lost sensor is forgotten, the comparisons about it are false until it sends readings again.
+*/
+type RuleEngine struct {
+	ea       *EventAggregator
+	broker   queueutils.Broker
+	pub      queueutils.Publisher // the broker itself, or a ReliablePublisher on top of it
+	producer *dto.Producer
+	settings config.Rules
+
+	mutex  sync.Mutex   // the rules are reloaded while the readings come in
+	rules  []*ruleState // in the order of the file
+	loaded os.FileInfo  // the file as it was when it was read last, whether it loaded or not
+	failed string       // why it didn't, so it's only told once
+}
+
+// ruleState is a rule and whether it fires
+type ruleState struct {
+	rules.Rule
+	state  dto.AlarmState
+	since  time.Time
+	latest time.Time // of the latest reading of its sensors, see resolve
+}
+
+// NewRuleEngine publishes the transitions with the codec for contentType, retryDelay is the one the data manager
+// declares PersistAlarmsQueue with, see queueutils.DeclareWorkQueue. The rules are loaded by Run.
+func NewRuleEngine(ea *EventAggregator, broker queueutils.Broker, reliable bool, contentType string, retryDelay time.Duration,
+	settings config.Rules) *RuleEngine {
+	re := RuleEngine{
+		ea:       ea,
+		broker:   broker,
+		pub:      alarmPublisher(broker, reliable, retryDelay),
+		settings: settings,
+	}
+
+	var err error
+	re.producer, err = dto.NewProducer(dto.ProducerID("coordinator/rules"), contentType)
+	if err != nil {
+		log.Fatalf("Failed to set up message encoding: %s", err)
+	}
+
+	SubscribeAsync(ea, ReadingReceived.All(), re.check, DefaultListenerQueueSize)
+	Subscribe(ea, SourceLost, re.forget)
+
+	return &re
+}
+
+// Run loads the rules file and looks for changes every ReloadInterval until ctx is done,
+// there's nothing to do without a file
+func (re *RuleEngine) Run(ctx context.Context) {
+	if re.settings.File == "" {
+		return
+	}
+
+	ticker := time.NewTicker(re.settings.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		re.reload()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reload loads the file if it has changed since it was read last
+func (re *RuleEngine) reload() {
+	path := re.settings.File
+
+	info, err := os.Stat(path)
+	if err != nil {
+		re.fail(err.Error())
+		return
+	}
+	// !!! the size as well, a file written twice within the resolution of the modification time
+	// would otherwise be missed
+	if re.loaded != nil && info.ModTime().Equal(re.loaded.ModTime()) && info.Size() == re.loaded.Size() {
+		return
+	}
+	re.loaded = info
+
+	loaded, err := rules.Load(path)
+	if err != nil {
+		re.fail(err.Error())
+		return
+	}
+	re.failed = ""
+
+	re.apply(loaded)
+	fmt.Printf("Loaded %d rules from %v\n", len(loaded), path)
+}
+
+func (re *RuleEngine) fail(reason string) {
+	if reason != re.failed {
+		fmt.Printf("Failed to load the rules, keeping the ones there are: %s\n", reason)
+	}
+	re.failed = reason
+}
+
+// apply replaces the rules with loaded
+func (re *RuleEngine) apply(loaded []rules.Rule) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+
+	old := make(map[string]*ruleState)
+	for _, r := range re.rules {
+		old[r.Name] = r
+	}
+
+	re.rules = make([]*ruleState, 0, len(loaded))
+	for _, l := range loaded {
+		r := old[l.Name]
+		delete(old, l.Name)
+
+		if r == nil || r.When != l.When || r.Severity != l.Severity {
+			if r != nil && r.state != dto.StateNormal {
+				re.change(r, dto.StateNormal, "", 0, r.resolveTime())
+			}
+			r = &ruleState{Rule: l, state: dto.StateNormal}
+		}
+		r.Description = l.Description
+
+		re.rules = append(re.rules, r)
+		Publish(re.ea, RuleChanged, r.status(false))
+	}
+
+	for _, r := range old {
+		if r.state != dto.StateNormal {
+			re.change(r, dto.StateNormal, "", 0, r.resolveTime())
+		}
+		Publish(re.ea, RuleChanged, r.status(true))
+	}
+}
+
+func (re *RuleEngine) check(ed EventData) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+
+	for _, r := range re.rules {
+		if !r.Condition.Uses(ed.Name) {
+			continue
+		}
+
+		if ed.Timestamp.After(r.latest) {
+			r.latest = ed.Timestamp
+		}
+
+		holds := r.Condition.Observe(ed.Name, ed.Value, ed.Timestamp)
+		if next := r.stateFor(holds); next != r.state {
+			re.change(r, next, ed.Name, ed.Value, ed.Timestamp)
+		}
+	}
+}
+
+// forget drops the readings of a lost sensor, which may resolve the rules about it
+func (re *RuleEngine) forget(src string) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+
+	for _, r := range re.rules {
+		if !r.Condition.Uses(src) {
+			continue
+		}
+
+		r.Condition.Forget(src)
+		if next := r.stateFor(r.Condition.Holds()); next != r.state {
+			re.change(r, next, src, 0, r.resolveTime())
+		}
+	}
+}
+
+// resolveTime is when the rule changes without a reading, after the readings of its sensors and its last
+// transition however far the clocks of the sensors are ahead of ours, see resolveTime
+func (r *ruleState) resolveTime() time.Time {
+	latest := r.latest
+	if r.since.After(latest) {
+		latest = r.since
+	}
+
+	return resolveTime(latest)
+}
+
+// stateFor returns the state of the rule while its condition holds or doesn't
+func (r *ruleState) stateFor(holds bool) dto.AlarmState {
+	if holds {
+		return r.Severity
+	}
+
+	return dto.StateNormal
+}
+
+// change puts the rule into state next, sensor is the one whose reading, of value, did it
+func (re *RuleEngine) change(r *ruleState, next dto.AlarmState, sensor string, value float64, at time.Time) {
+	t := dto.AlarmTransition{
+		Sensor:    sensor,
+		Rule:      r.Name,
+		From:      r.state,
+		To:        next,
+		Value:     value,
+		Timestamp: at,
+	}
+	r.state = next
+	r.since = at
+
+	Publish(re.ea, AlarmChanged, t)
+	Publish(re.ea, RuleChanged, r.status(false))
+	re.publish(t)
+}
+
+// status is how the web applications get the rule
+func (r *ruleState) status(removed bool) dto.RuleState {
+	return dto.RuleState{
+		Name:        r.Name,
+		Expression:  r.When,
+		Description: r.Description,
+		Severity:    r.Severity,
+		State:       r.state,
+		Since:       r.since,
+		Removed:     removed,
+	}
+}
+
+func (re *RuleEngine) publish(t dto.AlarmTransition) {
+	env, err := re.producer.WrapAlarmTransition(t)
+	if err != nil {
+		fmt.Printf("Failed to encode alarm of rule %v: %s\n", t.Rule, err)
+		return
+	}
+
+	err = re.pub.Publish(
+		queueutils.AlarmsExchange,    //exchange string,
+		t.Alarm(),                    //key string,
+		queueutils.ToPublishing(env)) //msg amqp.Publishing)
+
+	if err != nil {
+		fmt.Printf("Failed to publish alarm of rule %v: %s\n", t.Rule, err)
+	}
+}
+
+// Close waits until the transitions are with the broker or ctx is done, and closes the broker,
+// the readings have to have stopped coming in
+func (re *RuleEngine) Close(ctx context.Context) error {
+	var err error
+	if pub, ok := re.pub.(*queueutils.ReliablePublisher); ok {
+		err = pub.Close(ctx)
+	}
+
+	re.broker.Close()
+	return err
+}
@@ -0,0 +1,227 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+Condition is a parsed rule together with what it has seen of its sensors, Observe gives it every reading
+of them and tells whether the condition holds after it. It goes by the timestamps of the readings:
+1, a comparison holds while the latest reading of its sensor does, and is false before there is one
+2, a rate of change is taken between the latest two readings of its sensor, per second
+3, "for" holds once what it's applied to has held for the duration, it's looked at with every reading of the sensors
+
+A Condition isn't safe for concurrent use.
+*/
+type Condition struct {
+	text    string
+	root    node
+	sensors map[string]*series
+	now     time.Time // of the latest reading, readings of other sensors may come a bit out of order
+}
+
+// series is the latest two readings of a sensor
+type series struct {
+	value, prev float64
+	at, prevAt  time.Time
+	n           int // readings so far, up to 2
+}
+
+// Parse parses a condition, see the package documentation for the language
+func Parse(text string) (*Condition, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("at %d: expected AND, OR or the end, got %s", t.pos, t)
+	}
+
+	c := &Condition{text: text, root: root, sensors: make(map[string]*series)}
+	root.walk(func(n node) {
+		if cmp, ok := n.(*compareNode); ok {
+			c.sensors[cmp.sensor] = &series{}
+		}
+	})
+
+	return c, nil
+}
+
+// String returns the text the condition was parsed from
+func (c *Condition) String() string {
+	return c.text
+}
+
+// Sensors returns the sensors the condition is about, sorted
+func (c *Condition) Sensors() []string {
+	names := make([]string, 0, len(c.sensors))
+	for name := range c.sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Uses tells whether the condition is about sensor
+func (c *Condition) Uses(sensor string) bool {
+	_, ok := c.sensors[sensor]
+	return ok
+}
+
+// Observe takes a reading of sensor and tells whether the condition holds now,
+// a reading that isn't newer than the latest one of its sensor is left out
+func (c *Condition) Observe(sensor string, value float64, t time.Time) bool {
+	if s, ok := c.sensors[sensor]; ok && (s.n == 0 || t.After(s.at)) {
+		s.prev, s.prevAt = s.value, s.at
+		s.value, s.at = value, t
+		if s.n < 2 {
+			s.n++
+		}
+	}
+
+	if t.After(c.now) {
+		c.now = t
+	}
+
+	return c.root.eval(c)
+}
+
+// Holds tells whether the condition holds as of the latest reading, after Forget
+func (c *Condition) Holds() bool {
+	return c.root.eval(c)
+}
+
+// Forget drops the readings of sensor, its comparisons are false until it sends readings again
+func (c *Condition) Forget(sensor string) {
+	if s, ok := c.sensors[sensor]; ok {
+		*s = series{}
+	}
+}
+
+type node interface {
+	eval(c *Condition) bool
+	walk(f func(node))
+}
+
+type compareNode struct {
+	sensor    string
+	rate      bool
+	op        string
+	threshold float64 // per second for a rate
+}
+
+func (n *compareNode) eval(c *Condition) bool {
+	s := c.sensors[n.sensor]
+
+	v := s.value
+	if n.rate {
+		if s.n < 2 {
+			return false
+		}
+		v = (s.value - s.prev) / s.at.Sub(s.prevAt).Seconds()
+	} else if s.n < 1 {
+		return false
+	}
+
+	switch n.op {
+	case ">":
+		return v > n.threshold
+	case ">=":
+		return v >= n.threshold
+	case "<":
+		return v < n.threshold
+	case "<=":
+		return v <= n.threshold
+	case "==":
+		return v == n.threshold
+	case "!=":
+		return v != n.threshold
+	}
+
+	return false
+}
+
+func (n *compareNode) walk(f func(node)) {
+	f(n)
+}
+
+type andNode struct {
+	left, right node
+}
+
+// eval looks at both sides, so a "for" on the right keeps track even while the left doesn't hold
+func (n *andNode) eval(c *Condition) bool {
+	left := n.left.eval(c)
+	right := n.right.eval(c)
+	return left && right
+}
+
+func (n *andNode) walk(f func(node)) {
+	f(n)
+	n.left.walk(f)
+	n.right.walk(f)
+}
+
+type orNode struct {
+	left, right node
+}
+
+// eval looks at both sides, like andNode.eval
+func (n *orNode) eval(c *Condition) bool {
+	left := n.left.eval(c)
+	right := n.right.eval(c)
+	return left || right
+}
+
+func (n *orNode) walk(f func(node)) {
+	f(n)
+	n.left.walk(f)
+	n.right.walk(f)
+}
+
+type notNode struct {
+	n node
+}
+
+func (n *notNode) eval(c *Condition) bool {
+	return !n.n.eval(c)
+}
+
+func (n *notNode) walk(f func(node)) {
+	f(n)
+	n.n.walk(f)
+}
+
+// heldNode is "for", since is when n started holding, zero while it doesn't
+type heldNode struct {
+	n     node
+	d     time.Duration
+	since time.Time
+}
+
+func (n *heldNode) eval(c *Condition) bool {
+	if !n.n.eval(c) {
+		n.since = time.Time{}
+		return false
+	}
+
+	if n.since.IsZero() {
+		n.since = c.now
+	}
+
+	return c.now.Sub(n.since) >= n.d
+}
+
+func (n *heldNode) walk(f func(node)) {
+	f(n)
+	n.n.walk(f)
+}
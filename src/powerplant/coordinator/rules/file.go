@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+File is what a rules file holds, like
+
+	rules:
+	  - name: turbine_overheat
+	    when: turbine_temp > 500 for 30s AND coolant_flow < 2
+	    severity: Alarm
+	    description: the turbine is hot and the coolant isn't flowing
+*/
+type File struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a named condition, it fires with its Severity while the condition holds
+type Rule struct {
+	Name        string         `yaml:"name"`
+	When        string         `yaml:"when"`
+	Severity    dto.AlarmState `yaml:"severity"` // Warning or Alarm, Alarm if it's left out
+	Description string         `yaml:"description"`
+
+	Condition *Condition `yaml:"-"` // parsed from When by Load
+}
+
+// Load reads and parses the rules in the file at path, it fails on the first rule that doesn't parse
+func Load(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := File{}
+	// !!! strict like the configuration, a misspelled "when" would make a rule that never fires
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range f.Rules {
+		r := &f.Rules[i]
+
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: there are two rules named %s", path, r.Name)
+		}
+		names[r.Name] = true
+
+		if err := r.parse(); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %s", path, r.Name, err)
+		}
+	}
+
+	return f.Rules, nil
+}
+
+func (r *Rule) parse() error {
+	switch r.Severity {
+	case "":
+		r.Severity = dto.StateAlarm
+	case dto.StateWarning, dto.StateAlarm:
+	default:
+		return fmt.Errorf("severity must be %s or %s", dto.StateWarning, dto.StateAlarm)
+	}
+
+	if r.When == "" {
+		return errors.New("when must not be empty")
+	}
+
+	var err error
+	r.Condition, err = Parse(r.When)
+	return err
+}
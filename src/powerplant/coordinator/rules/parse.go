@@ -0,0 +1,369 @@
+// Package rules is the language of the alerting rules of the coordinator, conditions across sensors like
+//
+//	turbine_temp > 500 for 30s AND coolant_flow < 2
+//	rate of change of boiler_pressure_out > 0.5/s
+//
+// Grammar, keywords are case insensitive:
+//
+//	condition  = or
+//	or         = and { ("OR" | "||") and }
+//	and        = not { ("AND" | "&&") not }
+//	not        = ("NOT" | "!") not | held
+//	held       = primary [ "FOR" duration ]      a duration of Go, 30s, 1m30s
+//	primary    = "(" or ")" | operand op number [ "/" unit ]
+//	operand    = sensor | "rate" "(" sensor ")" | "rate of change of" sensor
+//	op         = ">" | ">=" | "<" | "<=" | "==" | "!="
+//	unit       = "s" | "m" | "min" | "h"      only for rates, per second if it's left out
+//
+// AND binds tighter than OR, FOR applies to what's right before it: "a > 1 AND b > 2 for 10s" only needs
+// b to be above 2 for 10s, "(a > 1 AND b > 2) for 10s" needs both to be at the same time.
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF    tokenKind = iota
+	tokenWord             // a sensor name or a keyword
+	tokenNumber           // a number, or a duration like 30s
+	tokenOp               // a comparison, see compareNode
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+	tokenSlash
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // of the first byte, counted from 1 for the error messages
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "the end"
+	}
+
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// lex splits a condition into its tokens, ending with a tokenEOF
+func lex(text string) ([]token, error) {
+	tokens := []token{}
+
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		start := i
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+
+		case c == '(' || c == ')' || c == '/':
+			kinds := map[rune]tokenKind{'(': tokenLParen, ')': tokenRParen, '/': tokenSlash}
+			tokens = append(tokens, token{kinds[c], string(c), start + 1})
+			i++
+			continue
+
+		case strings.HasPrefix(text[i:], "&&"), strings.HasPrefix(text[i:], "||"):
+			kind := tokenAnd
+			if c == '|' {
+				kind = tokenOr
+			}
+			tokens = append(tokens, token{kind, text[i : i+2], start + 1})
+			i += 2
+			continue
+
+		case strings.ContainsRune("<>=!", c):
+			i++
+			if i < len(text) && text[i] == '=' {
+				i++
+			}
+			op := text[start:i]
+			kind := tokenOp
+			switch {
+			case op == "!":
+				kind = tokenNot
+			case op == "=":
+				return nil, fmt.Errorf("at %d: '=' isn't a comparison, did you mean '=='?", start+1)
+			}
+			tokens = append(tokens, token{kind, op, start + 1})
+			continue
+
+		case isDigit(c) || c == '.' || c == '-' || c == '+':
+			// the sign goes with the number, there's no arithmetic it could be meant for,
+			// letters too, so a duration like 30s or 1m30s is one token, and 1e-3 is
+			i++
+			for i < len(text) {
+				d := rune(text[i])
+				exponentSign := (d == '-' || d == '+') && (text[i-1] == 'e' || text[i-1] == 'E')
+				if !isDigit(d) && d != '.' && !unicode.IsLetter(d) && !exponentSign {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, text[start:i], start + 1})
+			continue
+
+		case isWordStart(c):
+			for i < len(text) && isWordPart(rune(text[i])) {
+				i++
+			}
+			if i < len(text) && text[i] == '.' {
+				return nil, fmt.Errorf("at %d: '%s.': sensor names can't have dots in them", start+1, text[start:i])
+			}
+			tokens = append(tokens, word(text[start:i], start+1))
+			continue
+		}
+
+		return nil, fmt.Errorf("at %d: unexpected '%c'", start+1, c)
+	}
+
+	return append(tokens, token{tokenEOF, "", len(text) + 1}), nil
+}
+
+// word makes AND, OR and NOT operators, the other keywords depend on where they are
+func word(text string, pos int) token {
+	switch strings.ToUpper(text) {
+	case "AND":
+		return token{tokenAnd, text, pos}
+	case "OR":
+		return token{tokenOr, text, pos}
+	case "NOT":
+		return token{tokenNot, text, pos}
+	}
+
+	return token{tokenWord, text, pos}
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordStart(c rune) bool {
+	return c == '_' || unicode.IsLetter(c)
+}
+
+// isWordPart allows the dashes sensor names may have, dots they can't, see dto.CheckSensorName
+func isWordPart(c rune) bool {
+	return isWordStart(c) || isDigit(c) || c == '-'
+}
+
+// rateUnits turns a rate per unit into a rate per second
+var rateUnits = map[string]float64{
+	"s":   1,
+	"m":   60,
+	"min": 60,
+	"h":   3600,
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// keyword tells whether the next token is the word kw, and takes it if so
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind != tokenWord || !strings.EqualFold(t.text, kw) {
+		return false
+	}
+
+	p.take()
+	return true
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.take()
+	if t.kind != kind {
+		return t, fmt.Errorf("at %d: expected %s, got %s", t.pos, what, t)
+	}
+
+	return t, nil
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		p.take()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenAnd {
+		p.take()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) not() (node, error) {
+	if p.peek().kind == tokenNot {
+		p.take()
+		n, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+
+	return p.held()
+}
+
+func (p *parser) held() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.keyword("for") {
+		return n, nil
+	}
+
+	t, err := p.expect(tokenNumber, "a duration")
+	if err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(t.text)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("at %d: %s isn't a positive duration like 30s", t.pos, t)
+	}
+
+	return &heldNode{n: n, d: d}, nil
+}
+
+func (p *parser) primary() (node, error) {
+	if p.peek().kind == tokenLParen {
+		p.take()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+
+	c := &compareNode{}
+	var err error
+	c.sensor, c.rate, err = p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := p.expect(tokenOp, "a comparison")
+	if err != nil {
+		return nil, err
+	}
+	c.op = t.text
+
+	t, err = p.expect(tokenNumber, "a number")
+	if err != nil {
+		return nil, err
+	}
+	c.threshold, err = strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("at %d: %s isn't a number", t.pos, t)
+	}
+
+	if p.peek().kind == tokenSlash {
+		slash := p.take()
+		if !c.rate {
+			return nil, fmt.Errorf("at %d: only a rate of change has a unit", slash.pos)
+		}
+		unit, err := p.expect(tokenWord, "a unit, s, m or h")
+		if err != nil {
+			return nil, err
+		}
+		perSecond, ok := rateUnits[strings.ToLower(unit.text)]
+		if !ok {
+			return nil, fmt.Errorf("at %d: unknown unit %s, use s, m or h", unit.pos, unit)
+		}
+		c.threshold /= perSecond
+	}
+
+	return c, nil
+}
+
+// operand returns the sensor of a comparison, and whether it's about its rate of change
+func (p *parser) operand() (string, bool, error) {
+	t, err := p.expect(tokenWord, "a sensor")
+	if err != nil {
+		return "", false, err
+	}
+	if !strings.EqualFold(t.text, "rate") {
+		return t.text, false, nil
+	}
+
+	switch {
+	case p.peek().kind == tokenLParen:
+		p.take()
+		sensor, err := p.expect(tokenWord, "a sensor")
+		if err != nil {
+			return "", false, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return "", false, err
+		}
+		return sensor.text, true, nil
+
+	case p.keyword("of"):
+		for _, kw := range []string{"change", "of"} {
+			if !p.keyword(kw) {
+				return "", false, fmt.Errorf("at %d: expected 'rate of change of', got %s", p.peek().pos, p.peek())
+			}
+		}
+		sensor, err := p.expect(tokenWord, "a sensor")
+		if err != nil {
+			return "", false, err
+		}
+		return sensor.text, true, nil
+	}
+
+	// a sensor named rate
+	return t.text, false, nil
+}
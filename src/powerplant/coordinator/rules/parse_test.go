@@ -0,0 +1,155 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// step is a reading of sensor at second at, and whether the condition is expected to hold after it
+type step struct {
+	sensor string
+	value  float64
+	at     int
+	want   bool
+}
+
+// observe parses text and gives it the readings of steps
+func observe(t *testing.T, text string, steps []step) {
+	t.Helper()
+
+	c, err := Parse(text)
+	if err != nil {
+		t.Fatalf("%q: %s", text, err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, s := range steps {
+		if got := c.Observe(s.sensor, s.value, start.Add(time.Duration(s.at)*time.Second)); got != s.want {
+			t.Fatalf("%q, step %d (%s %g at %ds): expected %v, got %v", text, i, s.sensor, s.value, s.at, s.want, got)
+		}
+	}
+}
+
+func TestRequestedRules(t *testing.T) {
+	c, err := Parse("turbine_temp > 500 for 30s AND coolant_flow < 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensors := strings.Join(c.Sensors(), ","); sensors != "coolant_flow,turbine_temp" {
+		t.Fatalf("expected coolant_flow and turbine_temp, got %s", sensors)
+	}
+
+	observe(t, "turbine_temp > 500 for 30s AND coolant_flow < 2", []step{
+		{"coolant_flow", 1, 0, false},
+		{"turbine_temp", 510, 1, false},
+		{"turbine_temp", 520, 20, false},
+		{"turbine_temp", 520, 31, true},
+		{"coolant_flow", 3, 32, false},
+		{"coolant_flow", 1, 33, true},
+		{"turbine_temp", 400, 34, false},
+		{"turbine_temp", 600, 35, false}, // held from the start again
+		{"turbine_temp", 600, 65, true},
+	})
+
+	observe(t, "rate of change of boiler_pressure_out > 0.5/s", []step{
+		{"boiler_pressure_out", 1, 0, false}, // a rate takes two readings
+		{"boiler_pressure_out", 1.4, 1, false},
+		{"boiler_pressure_out", 2.5, 3, true}, // 0.55/s
+		{"boiler_pressure_out", 2.5, 4, false},
+	})
+}
+
+func TestPrecedence(t *testing.T) {
+	// a OR (b AND c), not (a OR b) AND c
+	observe(t, "a > 1 OR b > 1 AND c > 1", []step{
+		{"a", 2, 0, true},
+		{"b", 0, 0, true},
+		{"c", 0, 0, true},
+		{"a", 0, 1, false},
+	})
+
+	// (NOT a > 1) AND b > 1
+	observe(t, "NOT a > 1 AND b > 1", []step{
+		{"a", 0, 0, false},
+		{"b", 2, 0, true},
+		{"a", 2, 1, false},
+	})
+
+	observe(t, "!(a > 1 || b > 1) && c == 1", []step{
+		{"c", 1, 0, true},
+		{"b", 2, 0, false},
+		{"b", 0, 1, true},
+		{"c", 2, 1, false},
+	})
+}
+
+func TestForBinding(t *testing.T) {
+	// FOR only applies to b > 2, which has held since 0
+	observe(t, "a > 1 AND b > 2 for 10s", []step{
+		{"b", 3, 0, false},
+		{"a", 0, 0, false},
+		{"a", 2, 5, false},
+		{"b", 3, 10, true},
+	})
+
+	// FOR applies to both, which have only held together since 5
+	observe(t, "(a > 1 AND b > 2) for 10s", []step{
+		{"b", 3, 0, false},
+		{"a", 0, 0, false},
+		{"a", 2, 5, false},
+		{"b", 3, 10, false},
+		{"b", 3, 15, true},
+	})
+}
+
+func TestRates(t *testing.T) {
+	// all of them are 0.5 per second
+	for _, text := range []string{"rate(p) > 0.5", "RATE(p) > 0.5/s", "rate(p) > 30/m", "rate(p) > 30/min", "rate of change of p > 1800/h"} {
+		observe(t, text, []step{
+			{"p", 0, 0, false},
+			{"p", 0.4, 1, false},
+			{"p", 1, 2, true},
+			{"p", 0.9, 1, true}, // older readings are left out
+		})
+	}
+
+	// without "(" or "of" it's a sensor named rate
+	observe(t, "rate > 5", []step{{"rate", 6, 0, true}})
+}
+
+func TestNumbers(t *testing.T) {
+	observe(t, "a > -2", []step{{"a", -1, 0, true}, {"a", -3, 1, false}})
+	observe(t, "a < +5", []step{{"a", 4, 0, true}})
+	observe(t, "a >= 1e-3", []step{{"a", 0.001, 0, true}, {"a", 0.0005, 1, false}})
+	observe(t, "a > 1.5E+1", []step{{"a", 16, 0, true}, {"a", 15, 1, false}})
+	observe(t, "a != .5", []step{{"a", 0.5, 0, false}, {"a", 1, 1, true}})
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want string // in the error
+	}{
+		{"", "expected a sensor"},
+		{"a = 1", "did you mean '=='"},
+		{"a > 1/s", "only a rate of change has a unit"},
+		{"rate(a) > 1/y", "unknown unit"},
+		{"boiler.pressure > 1", "can't have dots"},
+		{"a >", "expected a number"},
+		{"a > b", "expected a number"},
+		{"a > 1x", "isn't a number"},
+		{"a > 1 for", "expected a duration"},
+		{"a > 1 for x", "expected a duration"},
+		{"a > 1 for -5s", "isn't a positive duration"},
+		{"(a > 1", "expected ')'"},
+		{"a > 1 b > 2", "expected AND, OR or the end"},
+		{"rate of a > 1", "expected 'rate of change of'"},
+		{"a # 1", "unexpected '#'"},
+	} {
+		_, err := Parse(c.text)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: expected an error with %q, got %v", c.text, c.want, err)
+		}
+	}
+}
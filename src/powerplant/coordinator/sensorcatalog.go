@@ -0,0 +1,196 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// missedHeartbeats is how many heartbeats in a row a sensor may miss before the catalog forgets about it
+const missedHeartbeats = 3
+
+// safeRangeTTL is how long the catalog takes the word of the sensor table for the safe range of a sensor,
+// so a range changed in the table is picked up without asking the table for every reading
+const safeRangeTTL = time.Minute
+
+/*
+SensorCatalog is the coordinator's registry of sensor metadata, built from the heartbeats the sensors
+send to SensorHeartbeatExchange. A heartbeat from a sensor the catalog doesn't know raises SourceDiscovered,
+so a sensor is picked up even if its announcement got lost, and SensorInfoChanged is raised whenever a
+sensor shows up or its setup changes.
+
+The safe ranges come from the sensor table, the one the web applications show, see SafeRange.
+*/
+type SensorCatalog struct {
+	broker queueutils.Broker
+	ea     *EventAggregator
+	table  store.SensorStore // nil leaves the safe ranges to the heartbeats
+
+	mutex    sync.RWMutex
+	sensors  map[string]dto.Heartbeat // the latest heartbeat of each sensor
+	received map[string]time.Time
+	ranges   map[string]tableRange // what the sensor table said about the safe range of each sensor
+}
+
+// tableRange is the safe range of a sensor in the sensor table, ok is false if there's none
+type tableRange struct {
+	min, max float64
+	ok       bool
+	asked    time.Time
+}
+
+// NewSensorCatalog looks up the safe ranges of the sensors in table, see SafeRange
+func NewSensorCatalog(ea *EventAggregator, broker queueutils.Broker, table store.SensorStore) *SensorCatalog {
+	sc := SensorCatalog{
+		broker:   broker,
+		ea:       ea,
+		table:    table,
+		sensors:  make(map[string]dto.Heartbeat),
+		received: make(map[string]time.Time),
+		ranges:   make(map[string]tableRange),
+	}
+
+	// !!! a lost source is announced again by its next heartbeat, that's how it gets consumed again
+	Subscribe(ea, SourceLost, sc.forget)
+
+	return &sc
+}
+
+// ListenForHeartbeats keeps the catalog up to date until ctx is done
+func (sc *SensorCatalog) ListenForHeartbeats(ctx context.Context) {
+	sc.broker.DeclareExchange(queueutils.SensorHeartbeatExchange, queueutils.FanoutExchange)
+
+	queueName := queueutils.GetQueue("", sc.broker, true)
+	sc.broker.BindQueue(
+		queueName,                          //queue string,
+		"",                                 //key string,
+		queueutils.SensorHeartbeatExchange) //exchange string)
+
+	msgs, err := queueutils.ConsumeContext(ctx, sc.broker,
+		queueName, //queue string,
+		"",        //consumer string,
+		true,      //autoAck bool,
+		false)     //exclusive bool)
+	if err != nil {
+		fmt.Printf("Failed to listen for heartbeats: %s\n", err)
+		return
+	}
+
+	for msg := range msgs {
+		hb, err := dto.DecodeHeartbeat(queueutils.FromDelivery(msg))
+		if err != nil {
+			fmt.Printf("Failed to decode heartbeat: %s\n", err)
+			continue
+		}
+
+		sc.update(hb)
+	}
+
+	fmt.Println("Stopped listening for heartbeats")
+}
+
+func (sc *SensorCatalog) update(hb dto.Heartbeat) {
+	sc.mutex.Lock()
+	prev, known := sc.sensors[hb.Name]
+	sc.sensors[hb.Name] = hb
+	sc.received[hb.Name] = time.Now()
+	sc.mutex.Unlock()
+
+	if !known {
+		Publish(sc.ea, SourceDiscovered, hb.Name)
+	}
+
+	if !known || !sameSetup(prev, hb) {
+		Publish(sc.ea, SensorInfoChanged, hb)
+	}
+}
+
+// sameSetup compares everything but what changes with every heartbeat
+func sameSetup(a, b dto.Heartbeat) bool {
+	a.Uptime, b.Uptime = 0, 0
+	a.Timestamp, b.Timestamp = time.Time{}, time.Time{}
+
+	return a == b
+}
+
+func (sc *SensorCatalog) forget(name string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	delete(sc.sensors, name)
+	delete(sc.received, name)
+	delete(sc.ranges, name)
+}
+
+// Get returns the latest heartbeat of a sensor, a sensor that has missed a few heartbeats isn't returned
+func (sc *SensorCatalog) Get(name string) (dto.Heartbeat, bool) {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	hb, ok := sc.sensors[name]
+	if !ok || time.Since(sc.received[name]) > missedHeartbeats*hb.Interval {
+		return dto.Heartbeat{}, false
+	}
+
+	return hb, true
+}
+
+/*
+SafeRange returns the safe range of a sensor:
+1, the one in the sensor table, the web applications shade it in the charts
+2, the one of its heartbeats if the sensor isn't in the table, or its row has no range, or the table can't be asked
+
+ok is false as long as neither is known. !!! sensors older than the safe range in the heartbeat send zeros,
+and so do the rows of the sensors registered before there were safe ranges, a range of zeros is none.
+*/
+func (sc *SensorCatalog) SafeRange(name string) (min, max float64, ok bool) {
+	if r := sc.tableRange(name); r.ok {
+		return r.min, r.max, true
+	}
+
+	hb, known := sc.Get(name)
+	if !known || hb.MinSafeValue >= hb.MaxSafeValue {
+		return 0, 0, false
+	}
+
+	return hb.MinSafeValue, hb.MaxSafeValue, true
+}
+
+func (sc *SensorCatalog) tableRange(name string) tableRange {
+	if sc.table == nil {
+		return tableRange{}
+	}
+
+	sc.mutex.RLock()
+	r, cached := sc.ranges[name]
+	sc.mutex.RUnlock()
+
+	if cached && time.Since(r.asked) < safeRangeTTL {
+		return r
+	}
+
+	// a table that can't be asked now is asked again after the TTL, the range it gave last is kept until then
+	next := tableRange{asked: time.Now()}
+	sensor, err := sc.table.SensorByName(name)
+	switch {
+	case err == nil:
+		next.min, next.max = sensor.MinSafeValue, sensor.MaxSafeValue
+		next.ok = next.min < next.max
+	case errors.Is(err, store.ErrNotFound):
+	default:
+		fmt.Printf("Failed to look up the safe range of %v: %s\n", name, err)
+		next.min, next.max, next.ok = r.min, r.max, r.ok
+	}
+
+	sc.mutex.Lock()
+	sc.ranges[name] = next
+	sc.mutex.Unlock()
+
+	return next
+}
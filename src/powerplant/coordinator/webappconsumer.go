@@ -1,8 +1,9 @@
 package coordinator
 
 import (
-	"bytes"
-	"encoding/gob"
+	"fmt"
+	"log"
+	"sync"
 
 	"github.com/golang-distributed-application/src/powerplant/dto"
 	"github.com/golang-distributed-application/src/powerplant/queueutils"
@@ -10,26 +11,50 @@ import (
 )
 
 type WebappConsumer struct {
-	er      EventRaiser
-	conn    *amqp.Connection
-	ch      *amqp.Channel
+	er       EventRaiser
+	broker   queueutils.Broker
+	producer *dto.Producer
+	catalog  *SensorCatalog
+	// sources' metadata gets a producer of its own, so the sequence numbers of the readings have no gaps
+	infoProducer *dto.Producer
+
+	mutex   sync.Mutex // sources are added by the event aggregator and read by ListenForDiscoveryRequests
 	sources []string
+	rules   map[string]dto.RuleState // the latest state of every rule, for the web applications asking
 }
 
-func NewWebappConsumer(er EventRaiser) *WebappConsumer {
+// NewWebappConsumer encodes the readings for the web applications with the codec for contentType, see dto.CodecFor,
+// the sources are sent along with their metadata from the catalog
+func NewWebappConsumer(er EventRaiser, broker queueutils.Broker, contentType string, catalog *SensorCatalog) *WebappConsumer {
 	wc := WebappConsumer{
-		er: er,
+		er:      er,
+		broker:  broker,
+		catalog: catalog,
+		rules:   make(map[string]dto.RuleState),
 	}
 
-	wc.conn, wc.ch = queueutils.GetChannel(url)
-	queueutils.GetQueue(queueutils.PersistReadingsQueue, wc.ch, false)
+	var err error
+	wc.producer, err = dto.NewProducer(dto.ProducerID("coordinator/webapp"), contentType)
+	if err != nil {
+		log.Fatalf("Failed to set up message encoding: %s", err)
+	}
+	wc.infoProducer, err = dto.NewProducer(dto.ProducerID("coordinator/webapp/sources"), contentType)
+	if err != nil {
+		log.Fatalf("Failed to set up message encoding: %s", err)
+	}
 
 	go wc.ListenForDiscoveryRequests()
 
-	wc.er.AddListener(queueutils.DataSourceDiscoveredEvent,
-		func(eventData interface{}) {
-			wc.SubscribeToDataEvent(eventData.(string))
-		})
+	Subscribe(wc.er, SourceDiscovered, wc.SubscribeToDataEvent)
+	Subscribe(wc.er, SourceLost, wc.sourceLost)
+	Subscribe(wc.er, SourceRecovered, func(src string) {
+		wc.publishSourceEvent(src, queueutils.SourceRecoveredMessage)
+	})
+	Subscribe(wc.er, SensorInfoChanged, wc.publishSourceInfo)
+	Subscribe(wc.er, RuleChanged, wc.ruleChanged)
+
+	// one listener for the readings of every source, they all go to the same exchange
+	SubscribeAsync(wc.er, ReadingReceived.All(), wc.publishReading, DefaultListenerQueueSize)
 
 	// declare exchanges
 	/* !!!
@@ -38,87 +63,176 @@ func NewWebappConsumer(er EventRaiser) *WebappConsumer {
 	we can easily create many-to-many relationship between coordinators and web applications,
 	and horizontally scale.
 	*/
-	wc.ch.ExchangeDeclare(
+	wc.broker.DeclareExchange(
 		queueutils.WebappSourceExchange, //name string,
-		"fanout",                        //kind string,
-		false,                           //durable bool,
-		false,                           //autoDelete bool,
-		false,                           //internal bool,
-		false,                           //noWait bool,
-		nil)                             //args amqp.Table)
-
-	wc.ch.ExchangeDeclare(
+		queueutils.FanoutExchange)       //kind string)
+
+	wc.broker.DeclareExchange(
 		queueutils.WebappReadingsExchange, //name string,
-		"fanout", //kind string,
-		false,    //durable bool,
-		false,    //autoDelete bool,
-		false,    //internal bool,
-		false,    //noWait bool,
-		nil)      //args amqp.Table)
+		queueutils.FanoutExchange)         //kind string)
 
 	return &wc
 }
 
 func (wc *WebappConsumer) ListenForDiscoveryRequests() {
-	queue := queueutils.GetQueue(queueutils.WebappDiscoveryQueue, wc.ch, false)
-	msgs, _ := wc.ch.Consume(
-		queue.Name, //queue string,
-		"",         //consumer string,
-		true,       //autoAck bool,
-		false,      //exclusive bool,
-		false,      //noLocal bool,
-		false,      //noWait bool,
-		nil)        //args amqp.Table)
+	queueName := queueutils.GetQueue(queueutils.WebappDiscoveryQueue, wc.broker, false)
+	msgs, _ := wc.broker.Consume(
+		queueName, //queue string,
+		"",        //consumer string,
+		true,      //autoAck bool,
+		false)     //exclusive bool)
 
 	for range msgs {
-		for _, src := range wc.sources {
+		wc.mutex.Lock()
+		sources := append([]string(nil), wc.sources...)
+		rules := make([]dto.RuleState, 0, len(wc.rules))
+		for _, rs := range wc.rules {
+			rules = append(rules, rs)
+		}
+		wc.mutex.Unlock()
+
+		for _, src := range sources {
 			wc.SendMessageSource(src)
+
+			if hb, ok := wc.catalog.Get(src); ok {
+				wc.publishSourceInfo(hb)
+			}
+		}
+
+		for _, rs := range rules {
+			wc.publishRuleState(rs)
 		}
 	}
+
+	fmt.Println("Stopped listening for discovery requests from web applications")
 }
 
 // SendMessageSource informs the web applications about the new sensor
 func (wc *WebappConsumer) SendMessageSource(src string) {
-	wc.ch.Publish(
+	wc.broker.Publish(
 		queueutils.WebappSourceExchange, //exchange string,
-		"",    //key string,
-		false, //mandatory bool,
-		false, //immediate bool,
+		"", //key string,
 		amqp.Publishing{Body: []byte(src)}) // msg amqp.Publishing)
 }
 
+// sourceLost forgets the source, so it isn't offered to web applications asking for sources any more,
+// and tells the ones showing it already
+func (wc *WebappConsumer) sourceLost(src string) {
+	wc.mutex.Lock()
+	for i, v := range wc.sources {
+		if v == src {
+			wc.sources = append(wc.sources[:i], wc.sources[i+1:]...)
+			break
+		}
+	}
+	wc.mutex.Unlock()
+
+	wc.publishSourceEvent(src, queueutils.SourceLostMessage)
+}
+
+// publishSourceInfo sends the metadata of a sensor, as a dto.Heartbeat envelope
+func (wc *WebappConsumer) publishSourceInfo(hb dto.Heartbeat) {
+	env, err := wc.infoProducer.WrapHeartbeat(hb)
+	if err != nil {
+		fmt.Printf("Failed to encode metadata of %v for web applications: %s\n", hb.Name, err)
+		return
+	}
+
+	err = wc.broker.Publish(
+		queueutils.WebappSourceExchange, //exchange string,
+		"",                              //key string,
+		queueutils.ToPublishing(env))    //msg amqp.Publishing)
+
+	if err != nil {
+		fmt.Printf("Failed to publish metadata of %v to web applications: %s\n", hb.Name, err)
+	}
+}
+
+// ruleChanged remembers the state of a rule and tells the web applications
+func (wc *WebappConsumer) ruleChanged(rs dto.RuleState) {
+	wc.mutex.Lock()
+	if rs.Removed {
+		delete(wc.rules, rs.Name)
+	} else {
+		wc.rules[rs.Name] = rs
+	}
+	wc.mutex.Unlock()
+
+	wc.publishRuleState(rs)
+}
+
+// publishRuleState sends the state of a rule, as a dto.RuleState envelope
+func (wc *WebappConsumer) publishRuleState(rs dto.RuleState) {
+	env, err := wc.infoProducer.WrapRuleState(rs)
+	if err != nil {
+		fmt.Printf("Failed to encode rule %v for web applications: %s\n", rs.Name, err)
+		return
+	}
+
+	err = wc.broker.Publish(
+		queueutils.WebappSourceExchange, //exchange string,
+		"",                              //key string,
+		queueutils.ToPublishing(env))    //msg amqp.Publishing)
+
+	if err != nil {
+		fmt.Printf("Failed to publish rule %v to web applications: %s\n", rs.Name, err)
+	}
+}
+
+// publishSourceEvent sends a message of type msgType about a source known to the web applications
+func (wc *WebappConsumer) publishSourceEvent(src, msgType string) {
+	err := wc.broker.Publish(
+		queueutils.WebappSourceExchange, //exchange string,
+		"",                              //key string,
+		amqp.Publishing{Type: msgType, Body: []byte(src)}) //msg amqp.Publishing)
+
+	if err != nil {
+		fmt.Printf("Failed to tell web applications about %v: %s\n", src, err)
+	}
+}
+
 func (wc *WebappConsumer) SubscribeToDataEvent(eventName string) {
+	wc.mutex.Lock()
 	for _, v := range wc.sources {
 		if v == eventName {
+			wc.mutex.Unlock()
 			return
 		}
 	}
 
 	wc.sources = append(wc.sources, eventName)
+	wc.mutex.Unlock()
 
 	wc.SendMessageSource(eventName)
+}
 
-	wc.er.AddListener(queueutils.MessageReceivedEvent+eventName,
-		func(eventData interface{}) {
-			ed := eventData.(EventData)
-			sensorMsg := dto.SensorMessage{
-				Name:      ed.Name,
-				Value:     ed.Value,
-				Timestamp: ed.Timestamp,
-			}
+// publishReading forwards a reading to the web applications
+func (wc *WebappConsumer) publishReading(ed EventData) {
+	sensorMsg := dto.SensorMessage{
+		Name:      ed.Name,
+		Value:     ed.Value,
+		Timestamp: ed.Timestamp,
+	}
 
-			buffer := new(bytes.Buffer)
-			encoder := gob.NewEncoder(buffer)
-			encoder.Encode(sensorMsg)
-			msg := amqp.Publishing{
-				Body: buffer.Bytes(),
-			}
+	env, err := wc.producer.WrapSensorMessage(sensorMsg)
+	if err != nil {
+		fmt.Printf("Failed to encode reading of %v for web applications: %s\n", ed.Name, err)
+		return
+	}
+	msg := queueutils.ToPublishing(env)
+
+	err = wc.broker.Publish(
+		queueutils.WebappReadingsExchange, //exchange string,
+		"",  //key string,
+		msg) //msg amqp.Publishing)
+
+	// the web apps only show live readings, so a lost one isn't worth retrying
+	if err != nil {
+		fmt.Printf("Failed to publish reading of %v to web applications: %s\n", ed.Name, err)
+	}
+}
 
-			wc.ch.Publish(
-				queueutils.WebappReadingsExchange, //exchange string,
-				"",    //key string,
-				false, //mandatory bool,
-				false, //immediate bool,
-				msg)   //msg amqp.Publishing)
-		})
+// Close stops answering the web applications and closes the broker, the readings for them aren't worth waiting for
+func (wc *WebappConsumer) Close() {
+	wc.broker.Close()
 }
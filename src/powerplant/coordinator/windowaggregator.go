@@ -0,0 +1,282 @@
+package coordinator
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
+
+/*
+WindowAggregator sums up the readings of every sensor over the configured windows, so the consumers that don't
+need every reading can take the aggregates of the window they need instead of sampling the readings themselves:
+1, a window is closed once a reading of its sensor comes in at or after its end, so it goes by the timestamps of the readings like the persistence policies
+2, its aggregate is raised as AggregateComputed.For(window).For(sensor) and published to AggregatesExchange with the routing key window.sensor
+3, a window without readings has no aggregate, and a reading of a window that's closed already is left out
+4, the windows still open when a sensor is lost or the coordinator stops are closed with what they have
+
+!!! every window of a sensor keeps the readings of its open windows, a sliding window of an hour keeps an hour of readings
+*/
+type WindowAggregator struct {
+	ea       *EventAggregator
+	broker   queueutils.Broker
+	producer *dto.Producer
+	windows  []config.Window
+
+	mutex   sync.Mutex                 // the readings come in on the listener, lost sources on the SourceMonitor's goroutine
+	sensors map[string][]*sensorWindow // the windows of every sensor, in the order of windows
+}
+
+// sensorWindow is a window of one sensor
+type sensorWindow struct {
+	settings config.Window
+	hop      time.Duration
+
+	readings []EventData // of the windows that are still open
+	end      time.Time   // of the next window to close, zero until there's a reading
+}
+
+/*
+NewWindowAggregator publishes the aggregates of windows with the codec for contentType, see dto.CodecFor. No windows, no aggregates.
+
+!!! they're published like the readings for the web applications, not through a queueutils.ReliablePublisher:
+the exchange may well have no queue bound to it, a mandatory message would come back unroutable and be tried again forever,
+and the next aggregate of the window makes up for a lost one anyway
+*/
+func NewWindowAggregator(ea *EventAggregator, broker queueutils.Broker, contentType string,
+	windows []config.Window) *WindowAggregator {
+	wa := WindowAggregator{
+		ea:      ea,
+		broker:  broker,
+		windows: windows,
+		sensors: make(map[string][]*sensorWindow),
+	}
+
+	var err error
+	wa.producer, err = dto.NewProducer(dto.ProducerID("coordinator/aggregates"), contentType)
+	if err != nil {
+		log.Fatalf("Failed to set up message encoding: %s", err)
+	}
+
+	wa.broker.DeclareExchange(
+		queueutils.AggregatesExchange, //name string,
+		queueutils.TopicExchange)      //kind string)
+
+	if len(windows) == 0 {
+		return &wa
+	}
+
+	// asynchronous and for every source at once, the readings of a sensor come in the order they were received
+	SubscribeAsync(ea, ReadingReceived.All(), wa.offer, DefaultListenerQueueSize)
+	Subscribe(ea, SourceLost, wa.sourceLost)
+
+	return &wa
+}
+
+func (wa *WindowAggregator) offer(ed EventData) {
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+
+	windows, ok := wa.sensors[ed.Name]
+	if !ok {
+		for _, w := range wa.windows {
+			windows = append(windows, newSensorWindow(w))
+		}
+		wa.sensors[ed.Name] = windows
+	}
+
+	for _, w := range windows {
+		for _, a := range w.offer(ed) {
+			wa.publish(a)
+		}
+	}
+}
+
+// sourceLost closes the open windows of a lost sensor, they start over with its next reading
+func (wa *WindowAggregator) sourceLost(src string) {
+	wa.mutex.Lock()
+	defer wa.mutex.Unlock()
+
+	wa.flush(src)
+}
+
+func (wa *WindowAggregator) flush(src string) {
+	for _, w := range wa.sensors[src] {
+		for _, a := range w.flush() {
+			wa.publish(a)
+		}
+	}
+
+	delete(wa.sensors, src)
+}
+
+// publish raises the aggregate and sends it to AggregatesExchange,
+// it's called with the lock held, so the aggregates of a window come out in order
+func (wa *WindowAggregator) publish(a dto.Aggregate) {
+	Publish(wa.ea, AggregateComputed.For(a.Window).For(a.Sensor), a)
+
+	env, err := wa.producer.WrapAggregate(a)
+	if err != nil {
+		log.Printf("Failed to encode the %v aggregate of %v: %s", a.Window, a.Sensor, err)
+		return
+	}
+
+	err = wa.broker.Publish(
+		queueutils.AggregatesExchange, //exchange string,
+		a.Window+"."+a.Sensor,         //key string,
+		queueutils.ToPublishing(env))  //msg amqp.Publishing)
+
+	if err != nil {
+		log.Printf("Failed to publish the %v aggregate of %v: %s", a.Window, a.Sensor, err)
+	}
+}
+
+// Close publishes the open windows of every sensor, once the readings have stopped coming in, and closes the broker
+func (wa *WindowAggregator) Close() {
+	wa.mutex.Lock()
+	for src := range wa.sensors {
+		wa.flush(src)
+	}
+	wa.mutex.Unlock()
+
+	wa.broker.Close()
+}
+
+func newSensorWindow(settings config.Window) *sensorWindow {
+	w := sensorWindow{settings: settings, hop: settings.Hop}
+	if w.hop == 0 {
+		w.hop = settings.Size
+	}
+
+	return &w
+}
+
+// firstEnd returns the end of the first window t is in
+func (w *sensorWindow) firstEnd(t time.Time) time.Time {
+	return t.Add(-w.settings.Size).Truncate(w.hop).Add(w.hop + w.settings.Size)
+}
+
+// offer returns the aggregates of the windows ed closes, and keeps ed for the windows it's in
+func (w *sensorWindow) offer(ed EventData) []dto.Aggregate {
+	if w.end.IsZero() {
+		w.end = w.firstEnd(ed.Timestamp)
+	}
+
+	// too late, the windows it's in are closed already
+	if ed.Timestamp.Before(w.end.Add(-w.settings.Size)) {
+		return nil
+	}
+
+	var done []dto.Aggregate
+	for !ed.Timestamp.Before(w.end) {
+		if a, ok := w.aggregate(); ok {
+			done = append(done, a)
+		}
+		w.end = w.end.Add(w.hop)
+		w.prune()
+
+		// the sensor has been quiet, there's nothing for the windows up to the one of ed
+		if len(w.readings) == 0 {
+			w.end = w.firstEnd(ed.Timestamp)
+		}
+	}
+
+	w.readings = append(w.readings, ed)
+	return done
+}
+
+// flush returns the aggregates of the open windows and starts over
+func (w *sensorWindow) flush() []dto.Aggregate {
+	var done []dto.Aggregate
+	for len(w.readings) > 0 {
+		if a, ok := w.aggregate(); ok {
+			done = append(done, a)
+		}
+		w.end = w.end.Add(w.hop)
+		w.prune()
+	}
+
+	w.end = time.Time{}
+	return done
+}
+
+// prune forgets the readings that aren't in an open window any more
+func (w *sensorWindow) prune() {
+	start := w.end.Add(-w.settings.Size)
+
+	kept := w.readings[:0]
+	for _, r := range w.readings {
+		if !r.Timestamp.Before(start) {
+			kept = append(kept, r)
+		}
+	}
+	w.readings = kept
+}
+
+// aggregate sums up the readings of the window ending at end, ok is false if there are none
+func (w *sensorWindow) aggregate() (a dto.Aggregate, ok bool) {
+	start := w.end.Add(-w.settings.Size)
+
+	values := []float64{}
+	for _, r := range w.readings {
+		if !r.Timestamp.Before(start) && r.Timestamp.Before(w.end) {
+			values = append(values, r.Value)
+		}
+	}
+	if len(values) == 0 {
+		return a, false
+	}
+
+	a = summarize(values, w.settings.Percentiles)
+	a.Sensor = w.readings[0].Name
+	a.Window = w.settings.Name
+	a.Start, a.End = start, w.end
+	return a, true
+}
+
+// summarize returns the statistics of values, it sorts them
+func summarize(values []float64, percentiles []float64) dto.Aggregate {
+	sort.Float64s(values)
+	n := float64(len(values))
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / n
+
+	squares := 0.0
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+
+	a := dto.Aggregate{
+		Count:  len(values),
+		Min:    values[0],
+		Max:    values[len(values)-1],
+		Mean:   mean,
+		StdDev: math.Sqrt(squares / n),
+	}
+
+	for _, p := range percentiles {
+		a.Percentiles = append(a.Percentiles, dto.Percentile{Rank: p, Value: percentile(values, p)})
+	}
+
+	return a
+}
+
+// percentile interpolates between the two readings of sorted closest to rank p, the median of 1, 2, 3 and 4 is 2.5
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
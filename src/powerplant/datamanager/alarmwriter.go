@@ -0,0 +1,112 @@
+package datamanager
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/golang-distributed-application/src/powerplant/store"
+	"github.com/streadway/amqp"
+)
+
+/*
+AlarmWriter saves the alarm transitions the coordinator publishes, and the actions of the operators the web
+applications publish, one at a time and acked one by one, there are few of them. Like the BatchWriter it puts a transition back in the queue after a backoff while
+the store is unavailable, and hands one the store refuses to the retrier.
+
+!!! its deliveries need a channel of their own, the BatchWriter's Ack(multiple) would ack them too
+*/
+type AlarmWriter struct {
+	backoff  queueutils.Backoff
+	retrier  *queueutils.Retrier
+	failures int // transitions in a row that couldn't be saved
+}
+
+func NewAlarmWriter(retrier *queueutils.Retrier) *AlarmWriter {
+	return &AlarmWriter{
+		backoff: queueutils.ReconnectBackoff,
+		retrier: retrier,
+	}
+}
+
+// Run saves the transitions coming in on msgs until the channel is closed,
+// the deliveries must not be acked automatically
+func (w *AlarmWriter) Run(msgs <-chan amqp.Delivery) {
+	for msg := range msgs {
+		w.save(msg)
+	}
+}
+
+func (w *AlarmWriter) save(msg amqp.Delivery) {
+	alarm, saveMsg, err := decodeAlarmMessage(queueutils.FromDelivery(msg))
+	if err != nil {
+		log.Printf("Failed to decode alarm message %v, dead-lettering it. Error: %s", msg.MessageId, err.Error())
+		msg.Reject(false)
+		return
+	}
+
+	err = saveMsg()
+	switch {
+	case err == nil:
+		w.failures = 0
+		if err := msg.Ack(false); err != nil {
+			log.Printf("Failed to ack the saved alarm message of %v. Error: %s", alarm, err.Error())
+		}
+
+	case errors.Is(err, store.ErrRejected):
+		w.failures = 0
+		log.Printf("Failed to save alarm message of %v (attempt %d). Error: %s", alarm, queueutils.Attempts(msg)+1, err.Error())
+		if err := w.retrier.Retry(msg, err); err != nil {
+			log.Printf("Failed to retry alarm message of %v, it's back in the queue. Error: %s", alarm, err.Error())
+		}
+
+	default:
+		delay := w.backoff.Delay(w.failures)
+		w.failures++
+		log.Printf("Failed to save alarm message of %v, putting it back in the queue in %v. Error: %s", alarm, delay, err.Error())
+
+		time.Sleep(delay)
+		if err := msg.Nack(false, true); err != nil {
+			log.Printf("Failed to put back alarm message of %v. Error: %s", alarm, err.Error())
+		}
+	}
+}
+
+// decodeAlarmMessage returns the alarm a transition or an action is about, and how to save it
+func decodeAlarmMessage(env dto.Envelope) (string, func() error, error) {
+	if env.Type == dto.AlarmActionType {
+		a, err := dto.DecodeAlarmAction(env)
+		return a.Alarm, func() error { return SaveAlarmAction(a) }, err
+	}
+
+	t, err := dto.DecodeAlarmTransition(env)
+	return t.Alarm(), func() error { return SaveAlarmTransition(t) }, err
+}
+
+// SaveAlarmTransition keeps a transition in the alarm history, a transition delivered twice is saved once
+func SaveAlarmTransition(t dto.AlarmTransition) error {
+	return db.SaveAlarmTransition(store.AlarmTransition{
+		Sensor:       t.Sensor,
+		Rule:         t.Rule,
+		From:         string(t.From),
+		To:           string(t.To),
+		Value:        t.Value,
+		MinSafeValue: t.MinSafeValue,
+		MaxSafeValue: t.MaxSafeValue,
+		TakenOn:      t.Timestamp,
+	})
+}
+
+// SaveAlarmAction keeps what an operator did about an alarm in the alarm history, like SaveAlarmTransition
+func SaveAlarmAction(a dto.AlarmAction) error {
+	return db.SaveAlarmAction(store.AlarmAction{
+		Alarm:        a.Alarm,
+		Kind:         string(a.Kind),
+		Operator:     a.Operator,
+		Comment:      a.Comment,
+		ShelvedUntil: a.ShelvedUntil,
+		TakenOn:      a.Timestamp,
+	})
+}
@@ -0,0 +1,162 @@
+package datamanager
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/golang-distributed-application/src/powerplant/store"
+	"github.com/streadway/amqp"
+)
+
+/*
+BatchWriter saves the readings to persist in batches instead of one INSERT and one ack per reading:
+a batch is written once it has maxRows readings or its first reading is maxDelay old, in one transaction,
+and then the whole batch is acked at once with Ack(multiple). If the transaction fails because the database
+is unavailable, the batch is nacked back to the queue, after a backoff so a database that's down isn't
+hammered with the same readings.
+
+A reading that can't be saved on its own goes through the retrier, which tries it again later and
+dead-letters it after the last attempt:
+1, a reading that doesn't decode is dead-lettered right away, it's never going to work
+2, a reading of a sensor that isn't in the database (yet)
+3, a batch the database refuses because of its data is saved one reading at a time, to single out the bad ones
+
+!!! Ack(multiple) also acks every earlier delivery of the channel, so a reading is never left unacked
+when it's left out of a batch.
+*/
+type BatchWriter struct {
+	maxRows  int
+	maxDelay time.Duration
+	backoff  queueutils.Backoff
+	retrier  *queueutils.Retrier
+
+	readings   []dto.SensorMessage
+	deliveries []amqp.Delivery // of the readings, acking the last one settles the whole batch
+	failures   int             // batches in a row that couldn't be written
+	flushTimer *time.Timer
+}
+
+func NewBatchWriter(maxRows int, maxDelay time.Duration, retrier *queueutils.Retrier) *BatchWriter {
+	return &BatchWriter{
+		maxRows:  maxRows,
+		maxDelay: maxDelay,
+		backoff:  queueutils.ReconnectBackoff,
+		retrier:  retrier,
+	}
+}
+
+// Run saves the readings coming in on msgs until the channel is closed, then writes what's left,
+// the deliveries must not be acked automatically
+func (w *BatchWriter) Run(msgs <-chan amqp.Delivery) {
+	var flush <-chan time.Time
+
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				w.flush()
+				return
+			}
+
+			w.add(msg)
+
+			if len(w.readings) == 1 && w.flushTimer == nil {
+				w.flushTimer = time.NewTimer(w.maxDelay)
+				flush = w.flushTimer.C
+			}
+			if len(w.readings) >= w.maxRows {
+				w.flush()
+			}
+
+		case <-flush:
+			w.flush()
+		}
+
+		if len(w.readings) == 0 {
+			flush = nil
+		}
+	}
+}
+
+func (w *BatchWriter) add(msg amqp.Delivery) {
+	sensorMsg, err := dto.DecodeSensorMessage(queueutils.FromDelivery(msg))
+	if err != nil {
+		log.Printf("Failed to decode reading message %v, dead-lettering it. Error: %s", msg.MessageId, err.Error())
+		msg.Reject(false)
+		return
+	}
+
+	// if the database can't be asked the reading goes into the batch anyway, which then fails like a batch does
+	if _, err := Sensors.ID(sensorMsg.Name); errors.Is(err, ErrUnknownSensor) {
+		w.retry(msg, sensorMsg, err)
+		return
+	}
+
+	w.readings = append(w.readings, sensorMsg)
+	w.deliveries = append(w.deliveries, msg)
+}
+
+func (w *BatchWriter) retry(msg amqp.Delivery, reading dto.SensorMessage, reason error) {
+	log.Printf("Failed to save reading of %v (attempt %d). Error: %s", reading.Name, queueutils.Attempts(msg)+1, reason.Error())
+
+	if err := w.retrier.Retry(msg, reason); err != nil {
+		log.Printf("Failed to retry reading of %v, it's back in the queue. Error: %s", reading.Name, err.Error())
+	}
+}
+
+func (w *BatchWriter) flush() {
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+		w.flushTimer = nil
+	}
+
+	if len(w.readings) == 0 {
+		return
+	}
+
+	last := w.deliveries[len(w.deliveries)-1]
+
+	err := SaveReadings(w.readings)
+	switch {
+	case err == nil:
+		w.failures = 0
+		if err := last.Ack(true); err != nil {
+			// they are saved but the broker will deliver them again, the duplicates can't be helped
+			log.Printf("Failed to ack %d saved readings. Error: %s", len(w.readings), err.Error())
+		}
+
+	case errors.Is(err, store.ErrRejected):
+		w.failures = 0
+		log.Printf("Failed to save %d readings, saving them one by one. Error: %s", len(w.readings), err.Error())
+		w.saveEach()
+
+	default:
+		delay := w.backoff.Delay(w.failures)
+		w.failures++
+		log.Printf("Failed to save %d readings, putting them back in the queue in %v. Error: %s",
+			len(w.readings), delay, err.Error())
+
+		time.Sleep(delay)
+		if err := last.Nack(true, true); err != nil {
+			log.Printf("Failed to put back %d readings. Error: %s", len(w.readings), err.Error())
+		}
+	}
+
+	w.readings = w.readings[:0]
+	w.deliveries = w.deliveries[:0]
+}
+
+func (w *BatchWriter) saveEach() {
+	for i := range w.readings {
+		reading, msg := w.readings[i], w.deliveries[i]
+
+		if err := SaveReading(&reading); err != nil {
+			w.retry(msg, reading, err)
+		} else {
+			msg.Ack(false)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// deadletters looks after the readings the data manager has given up on, which wait in the PersistReadings.DLQ queue
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/streadway/amqp"
+)
+
+var limit = flag.Int("n", 0, "how many dead-lettered readings to list or replay, 0 for all of them")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] list|replay|purge
+  list    prints the dead-lettered readings and leaves them in the queue
+  replay  sends the dead-lettered readings back to the data manager, with all of their attempts ahead of them
+  purge   drops all of the dead-lettered readings
+
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	cfg := config.MustLoad()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	broker := queueutils.GetBroker(cfg.AMQP.URL)
+	defer broker.Close()
+
+	inspector, ok := broker.(queueutils.QueueInspector)
+	if !ok {
+		log.Fatalln("The message broker can't inspect queues.")
+	}
+
+	// declares them all, in case nothing has run yet
+	queue := queueutils.GetWorkQueue(queueutils.PersistReadingsQueue, broker, cfg.Datamanager.RetryDelay)
+	dlq := queueutils.DeadLetterQueueName(queue)
+
+	var err error
+	switch flag.Arg(0) {
+	case "list":
+		err = list(inspector, dlq)
+	case "replay":
+		err = replay(inspector, broker, dlq, queue)
+	case "purge":
+		var n int
+		n, err = inspector.Purge(dlq)
+		if err == nil {
+			fmt.Printf("Purged %d dead-lettered readings\n", n)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("Failed to %s the dead-lettered readings: %s", flag.Arg(0), err)
+	}
+}
+
+// list takes the readings off the queue without acking them, then puts them all back
+func list(inspector queueutils.QueueInspector, dlq string) error {
+	var last *amqp.Delivery
+	defer func() {
+		if last != nil {
+			last.Nack(true, true)
+		}
+	}()
+
+	for n := 0; *limit == 0 || n < *limit; n++ {
+		msg, ok, err := inspector.Get(dlq, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Printf("%d dead-lettered readings\n", n)
+			return nil
+		}
+		last = &msg
+
+		printReading(n+1, msg)
+	}
+
+	return nil
+}
+
+func printReading(n int, msg amqp.Delivery) {
+	reading, err := dto.DecodeSensorMessage(queueutils.FromDelivery(msg))
+	if err != nil {
+		fmt.Printf("%d: message %v from %v, %d bytes of %v that don't decode: %s\n",
+			n, msg.MessageId, msg.AppId, len(msg.Body), msg.ContentType, err)
+	} else {
+		fmt.Printf("%d: %v = %v at %v\n", n, reading.Name, reading.Value, reading.Timestamp)
+	}
+
+	fmt.Printf("   retries: %d", queueutils.Attempts(msg))
+	if reason, ok := msg.Headers[queueutils.LastErrorHeader].(string); ok {
+		fmt.Printf(", last error: %s", reason)
+	}
+	for _, d := range queueutils.Deaths(msg) {
+		fmt.Printf(", %s from %s (%d times)", d.Reason, d.Queue, d.Count)
+	}
+	fmt.Println()
+}
+
+// replay moves the readings to the work queue one at a time, a reading is only acked once it's back there
+func replay(inspector queueutils.QueueInspector, pub queueutils.Publisher, dlq, queue string) error {
+	n := 0
+	for ; *limit == 0 || n < *limit; n++ {
+		msg, ok, err := inspector.Get(dlq, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		replayed := queueutils.ClearAttempts(queueutils.Republishing(msg))
+		if err := pub.Publish("", queue, replayed); err != nil {
+			msg.Nack(false, true)
+			return err
+		}
+		msg.Ack(false)
+	}
+
+	fmt.Printf("Replayed %d dead-lettered readings\n", n)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/datamanager/migrate"
+	"github.com/golang-distributed-application/src/powerplant/store"
+	_ "github.com/lib/pq"
+)
+
+const migrateUsage = `Usage: main [flags] migrate up|down|status [version]
+  up [version]    applies the migrations up to version, or all of them
+  down [version]  reverts the migrations newer than version, or the newest one
+  status          lists the migrations and whether they have been applied`
+
+// runMigrate is the migrate subcommand, args are what comes after "migrate"
+func runMigrate(cfg *config.Config, args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		log.Fatalln(migrateUsage)
+	}
+
+	// the files of the file store need no tables
+	if cfg.Store.Backend != store.PostgresBackend {
+		fmt.Printf("The %s store has no migrations.\n", cfg.Store.Backend)
+		return
+	}
+
+	db, err := sql.Open("postgres", cfg.Postgres.DSN)
+	if err != nil {
+		log.Fatalf("Failed to open the database: %s", err)
+	}
+	defer db.Close()
+
+	version := -1
+	if len(args) == 2 {
+		version, err = strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			log.Fatalln(migrateUsage)
+		}
+	}
+
+	var done []migrate.Migration
+	switch args[0] {
+	case "up":
+		done, err = migrate.Up(db, version)
+		report("Applied", done, err)
+
+	case "down":
+		if version < 0 {
+			// one step back
+			current, err := migrate.Current(db)
+			if err != nil {
+				log.Fatalf("Failed to read the schema version: %s", err)
+			}
+			version = previous(current)
+		}
+		done, err = migrate.Down(db, version)
+		report("Reverted", done, err)
+
+	case "status":
+		err = status(db)
+
+	default:
+		log.Fatalln(migrateUsage)
+	}
+
+	if err != nil {
+		log.Fatalf("Failed to migrate the database: %s", err)
+	}
+}
+
+// previous is the version of the migration before the given one, 0 if it's the first
+func previous(version int) int {
+	migrations, _ := migrate.Migrations()
+
+	prev := 0
+	for _, m := range migrations {
+		if m.Version < version {
+			prev = m.Version
+		}
+	}
+
+	return prev
+}
+
+// report lists the migrations done, a failed run lists the ones done before the failure
+func report(verb string, done []migrate.Migration, err error) {
+	for _, m := range done {
+		fmt.Printf("%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+
+	if err == nil && len(done) == 0 {
+		fmt.Println("The database is up to date")
+	}
+}
+
+func status(db *sql.DB) error {
+	statuses, err := migrate.List(db)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		switch {
+		case s.Missing:
+			fmt.Printf("%04d_%s  applied %s, unknown to this program\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+		case s.Applied:
+			fmt.Printf("%04d_%s  applied %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+		default:
+			fmt.Printf("%04d_%s  pending\n", s.Version, s.Name)
+		}
+	}
+
+	return nil
+}
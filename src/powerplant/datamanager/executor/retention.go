@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/datamanager"
+)
+
+// runRetention is the retention subcommand, it rolls up and deletes what's due and exits
+func runRetention(cfg *config.Config) {
+	s, err := cfg.OpenStore()
+	if err != nil {
+		log.Fatalf("Failed to open the store: %s", err)
+	}
+	datamanager.Open(s, cfg.Datamanager.UnknownSensorTTL)
+	defer datamanager.Close()
+
+	retention, err := datamanager.NewRetention(retentionPolicy(cfg))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := retention.RunOnce(time.Now()); err != nil {
+		log.Fatalf("Failed to apply the retention policy: %s", err)
+	}
+}
+
+func retentionPolicy(cfg *config.Config) datamanager.RetentionPolicy {
+	return datamanager.RetentionPolicy{
+		Lateness:     cfg.Datamanager.Retention.Lateness,
+		RawAge:       cfg.Datamanager.Retention.RawAge,
+		SensorRawAge: cfg.Datamanager.Retention.SensorRawAge,
+	}
+}
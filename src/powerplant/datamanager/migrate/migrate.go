@@ -0,0 +1,264 @@
+// migrate creates and upgrades the tables of the plant's database, see the sql directory for the migrations
+package migrate
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+Every migration is a pair of files in sql/, NNNN_name.up.sql and NNNN_name.down.sql, applied in the order
+of their versions. The schema_version table has a row for each migration applied to the database.
+
+Each migration runs in a transaction of its own along with its schema_version row, holding an advisory lock,
+so a migration is either applied completely or not at all, even with several data managers starting at once.
+*/
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the key of the advisory lock the migrations hold, any number no other code of the plant uses
+const lockID = 7_370_617
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration along with whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool // applied to the database but not known to this program, it's newer
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migrations returns the migrations built into the program, the oldest first
+func Migrations() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file %s isn't named NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		body, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is called both %s and %s", version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest is the version of the newest migration built into the program
+func Latest() (int, error) {
+	migrations, err := Migrations()
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func ensureVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS schema_version (
+      version    integer PRIMARY KEY,
+      name       text NOT NULL,
+      applied_at timestamptz NOT NULL DEFAULT now()
+    )
+  `)
+	return err
+}
+
+// List returns every migration, built into the program or applied to the database, the oldest first
+func List(db *sql.DB) ([]Status, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT version, name, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]Status)
+	for rows.Next() {
+		s := Status{Applied: true}
+		if err := rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, m := range migrations {
+		s := Status{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.Applied, s.AppliedAt = true, a.AppliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, s)
+	}
+
+	for _, a := range applied {
+		a.Missing = true
+		statuses = append(statuses, a)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies the migrations up to and including target, which are all of them if target is negative,
+// and returns the ones it has applied
+func Up(db *sql.DB, target int) ([]Migration, error) {
+	statuses, err := List(db)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, s := range statuses {
+		if s.Applied || (target >= 0 && s.Version > target) {
+			continue
+		}
+
+		if err := apply(db, s.Migration, true); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %s", s.Version, s.Name, err)
+		}
+		done = append(done, s.Migration)
+	}
+
+	return done, nil
+}
+
+// Down reverts the migrations newer than target, the newest first, and returns the ones it has reverted
+func Down(db *sql.DB, target int) ([]Migration, error) {
+	statuses, err := List(db)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		s := statuses[i]
+		if !s.Applied || s.Version <= target {
+			continue
+		}
+
+		if s.Missing {
+			return done, fmt.Errorf("migration %d_%s isn't known to this program, it can't be reverted", s.Version, s.Name)
+		}
+
+		if err := apply(db, s.Migration, false); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %s", s.Version, s.Name, err)
+		}
+		done = append(done, s.Migration)
+	}
+
+	return done, nil
+}
+
+// Current is the version of the newest migration applied to the database, 0 if there is none
+func Current(db *sql.DB) (int, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// apply runs one migration up or down, it does nothing if somebody else has been quicker
+func apply(db *sql.DB, m Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return err
+	}
+
+	var applied bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_version WHERE version = $1)`, m.Version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied == up {
+		return nil
+	}
+
+	if up {
+		_, err = tx.Exec(m.Up)
+	} else {
+		_, err = tx.Exec(m.Down)
+	}
+	if err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_version (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_version WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
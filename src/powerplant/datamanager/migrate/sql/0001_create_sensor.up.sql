@@ -0,0 +1,9 @@
+-- the sensors of the plant, the readings refer to them by id
+CREATE TABLE IF NOT EXISTS sensor (
+    id             serial PRIMARY KEY,
+    name           text NOT NULL UNIQUE,
+    serial_no      text NOT NULL DEFAULT '',
+    unit_type      text NOT NULL DEFAULT '',
+    min_safe_value double precision NOT NULL DEFAULT 0,
+    max_safe_value double precision NOT NULL DEFAULT 0
+);
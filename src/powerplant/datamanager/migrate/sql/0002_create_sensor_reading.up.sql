@@ -0,0 +1,10 @@
+-- every reading the data manager has saved
+CREATE TABLE IF NOT EXISTS sensor_reading (
+    id        bigserial PRIMARY KEY,
+    value     double precision NOT NULL,
+    sensor_id integer NOT NULL REFERENCES sensor (id),
+    taken_on  timestamptz NOT NULL
+);
+
+-- the readings are looked up by sensor and time
+CREATE INDEX IF NOT EXISTS sensor_reading_sensor_id_taken_on_idx ON sensor_reading (sensor_id, taken_on);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS sensor_reading_taken_on_idx;
+DROP TABLE IF EXISTS rollup;
+DROP TABLE IF EXISTS sensor_reading_1h;
+DROP TABLE IF EXISTS sensor_reading_1m;
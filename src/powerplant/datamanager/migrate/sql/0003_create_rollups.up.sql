@@ -0,0 +1,29 @@
+-- the readings folded into a row per sensor and minute, and per sensor and hour, see store.RetentionStore
+CREATE TABLE IF NOT EXISTS sensor_reading_1m (
+    sensor_id integer NOT NULL REFERENCES sensor (id),
+    bucket    timestamptz NOT NULL,
+    min_value double precision NOT NULL,
+    max_value double precision NOT NULL,
+    avg_value double precision NOT NULL,
+    count     integer NOT NULL,
+    PRIMARY KEY (sensor_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS sensor_reading_1h (
+    sensor_id integer NOT NULL REFERENCES sensor (id),
+    bucket    timestamptz NOT NULL,
+    min_value double precision NOT NULL,
+    max_value double precision NOT NULL,
+    avg_value double precision NOT NULL,
+    count     integer NOT NULL,
+    PRIMARY KEY (sensor_id, bucket)
+);
+
+-- up to where each resolution is rolled up
+CREATE TABLE IF NOT EXISTS rollup (
+    resolution   text PRIMARY KEY,
+    rolled_up_to timestamptz NOT NULL
+);
+
+-- the rollups read the readings by time, whatever their sensor
+CREATE INDEX IF NOT EXISTS sensor_reading_taken_on_idx ON sensor_reading (taken_on);
@@ -0,0 +1,15 @@
+-- every change of the alarm state of a sensor, as the coordinator publishes them
+CREATE TABLE IF NOT EXISTS alarm_transition (
+    id             bigserial PRIMARY KEY,
+    sensor         text NOT NULL,
+    from_state     text NOT NULL,
+    to_state       text NOT NULL,
+    value          double precision NOT NULL,
+    min_safe_value double precision NOT NULL,
+    max_safe_value double precision NOT NULL,
+    taken_on       timestamptz NOT NULL,
+    -- a transition delivered twice is saved once
+    UNIQUE (sensor, taken_on, to_state)
+);
+
+CREATE INDEX IF NOT EXISTS alarm_transition_taken_on_idx ON alarm_transition (taken_on);
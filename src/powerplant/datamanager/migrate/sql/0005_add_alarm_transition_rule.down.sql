@@ -0,0 +1,7 @@
+DELETE FROM alarm_transition WHERE rule <> '';
+
+ALTER TABLE alarm_transition DROP CONSTRAINT IF EXISTS alarm_transition_sensor_rule_taken_on_to_state_key;
+ALTER TABLE alarm_transition ADD CONSTRAINT alarm_transition_sensor_taken_on_to_state_key
+    UNIQUE (sensor, taken_on, to_state);
+
+ALTER TABLE alarm_transition DROP COLUMN IF EXISTS rule;
@@ -0,0 +1,6 @@
+-- the transitions of the coordinator's rules, a rule is fired by the reading of a sensor like a safe range is
+ALTER TABLE alarm_transition ADD COLUMN IF NOT EXISTS rule text NOT NULL DEFAULT '';
+
+ALTER TABLE alarm_transition DROP CONSTRAINT IF EXISTS alarm_transition_sensor_taken_on_to_state_key;
+ALTER TABLE alarm_transition ADD CONSTRAINT alarm_transition_sensor_rule_taken_on_to_state_key
+    UNIQUE (sensor, rule, taken_on, to_state);
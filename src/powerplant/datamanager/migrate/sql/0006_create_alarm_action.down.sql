@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS alarm_transition_alarm_taken_on_idx;
+ALTER TABLE alarm_transition DROP COLUMN IF EXISTS alarm;
+
+DROP TABLE IF EXISTS alarm_action;
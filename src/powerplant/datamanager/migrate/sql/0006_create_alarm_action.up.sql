@@ -0,0 +1,20 @@
+-- what the operators did about the alarms, an alarm is a sensor or "rule." and the name of a rule
+CREATE TABLE IF NOT EXISTS alarm_action (
+    id            bigserial PRIMARY KEY,
+    alarm         text NOT NULL,
+    kind          text NOT NULL,
+    operator      text NOT NULL,
+    comment       text NOT NULL DEFAULT '',
+    shelved_until timestamptz,
+    taken_on      timestamptz NOT NULL,
+    -- an action delivered twice is saved once
+    UNIQUE (alarm, kind, operator, taken_on)
+);
+
+CREATE INDEX IF NOT EXISTS alarm_action_taken_on_idx ON alarm_action (taken_on);
+
+-- the transitions under the same name as the actions
+ALTER TABLE alarm_transition ADD COLUMN IF NOT EXISTS alarm text
+    GENERATED ALWAYS AS (CASE WHEN rule <> '' THEN 'rule.' || rule ELSE sensor END) STORED;
+
+CREATE INDEX IF NOT EXISTS alarm_transition_alarm_taken_on_idx ON alarm_transition (alarm, taken_on);
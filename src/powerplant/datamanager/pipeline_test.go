@@ -0,0 +1,108 @@
+package datamanager_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/coordinator"
+	"github.com/golang-distributed-application/src/powerplant/datamanager"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/golang-distributed-application/src/powerplant/sensors"
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// TestPipeline runs a sensor, a coordinator and a data manager on the in-process broker,
+// and waits for the readings of the sensor to be in the store
+func TestPipeline(t *testing.T) {
+	// the sensor loads the configuration itself
+	t.Setenv("POWERPLANT_CONFIG", "")
+	// a server of its own every run, the sensor waits below for its queue to be declared
+	t.Setenv("POWERPLANT_AMQP_URL", fmt.Sprintf("memory://pipeline-%d", time.Now().UnixNano()))
+	t.Setenv("POWERPLANT_STORE_BACKEND", store.FileBackend)
+	t.Setenv("POWERPLANT_STORE_DIR", t.TempDir())
+	flag.Set("name", "boiler_pressure_out")
+	flag.Set("freq", "50")
+
+	cfg := config.MustLoad()
+	cfg.Coordinator.Persistence.Default = config.PersistencePolicy{Policy: config.IntervalPolicy} // every reading
+	cfg.Datamanager.BatchDelay = 20 * time.Millisecond
+
+	s, err := cfg.OpenStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddSensor(store.Sensor{Name: "boiler_pressure_out", MinSafeValue: 1, MaxSafeValue: 5}); err != nil {
+		t.Fatal(err)
+	}
+	boiler, err := s.SensorByName("boiler_pressure_out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	datamanager.Open(s, cfg.Datamanager.UnknownSensorTTL)
+	defer datamanager.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the data manager, like its executor does it
+	broker := queueutils.GetBroker(cfg.AMQP.URL)
+	defer broker.Close()
+	queueName := queueutils.GetWorkQueue(queueutils.PersistReadingsQueue, broker, cfg.Datamanager.RetryDelay)
+	msgs, err := queueutils.ConsumeContext(ctx, broker, queueName, "", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		retrier := queueutils.NewRetrier(broker, queueName, cfg.Datamanager.MaxAttempts)
+		datamanager.NewBatchWriter(cfg.Datamanager.BatchSize, cfg.Datamanager.BatchDelay, retrier).Run(msgs)
+	}()
+
+	// the sensor first, it applies the configuration again, the coordinator starts once it's publishing
+	published := make(chan error, 1)
+	go func() { published <- sensor.StartPublishingSensorData(ctx) }()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := broker.(queueutils.QueueInspector).Purge("boiler_pressure_out"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sensor to declare its queue")
+		}
+	}
+	coordinated := make(chan error, 1)
+	go func() { coordinated <- coordinator.StartConsumingSensorData(ctx, cfg) }()
+
+	started := time.Now()
+	var readings []store.Reading
+	for deadline := time.Now().Add(5 * time.Second); len(readings) < 10; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 10 readings in the store, got %d", len(readings))
+		}
+		if readings, err = s.Readings(store.ReadingQuery{SensorID: boiler.ID, From: started.Add(-time.Minute), To: time.Now().Add(time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range readings {
+		if r.Value < 1 || r.Value > 5 {
+			t.Fatalf("expected the readings to be between -min and -max, got %+v", r)
+		}
+	}
+
+	cancel()
+	for _, done := range []chan error{published, coordinated} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(config.ShutdownTimeout + time.Second):
+			t.Fatal("expected the sensor and the coordinator to stop")
+		}
+	}
+	<-saved
+}
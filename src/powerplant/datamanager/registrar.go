@@ -0,0 +1,93 @@
+package datamanager
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/streadway/amqp"
+)
+
+/*
+Registrar adds the sensors that aren't in the sensor table yet, with the metadata they send in their heartbeats,
+and tells everybody caching the table about it on SensorRegistrationExchange. The readings a sensor sends before
+it's registered go around the retry queue until it is, see BatchWriter.
+
+!!! it's opt-in, a plant whose sensor table is looked after by hand doesn't want every typo on the
+command line of a sensor to end up in it
+*/
+type Registrar struct {
+	broker queueutils.Broker
+}
+
+func NewRegistrar(broker queueutils.Broker) *Registrar {
+	return &Registrar{broker: broker}
+}
+
+// ListenForHeartbeats registers the unknown sensors as their heartbeats come in, until ctx is done
+func (r *Registrar) ListenForHeartbeats(ctx context.Context) {
+	r.broker.DeclareExchange(queueutils.SensorHeartbeatExchange, queueutils.FanoutExchange)
+	r.broker.DeclareExchange(queueutils.SensorRegistrationExchange, queueutils.FanoutExchange)
+
+	queueName := queueutils.GetQueue("", r.broker, true)
+	r.broker.BindQueue(
+		queueName,                          //queue string,
+		"",                                 //key string,
+		queueutils.SensorHeartbeatExchange) //exchange string)
+
+	msgs, err := queueutils.ConsumeContext(ctx, r.broker,
+		queueName, //queue string,
+		"",        //consumer string,
+		true,      //autoAck bool,
+		false)     //exclusive bool)
+	if err != nil {
+		log.Printf("Failed to listen for heartbeats. Error: %s", err.Error())
+		return
+	}
+
+	for msg := range msgs {
+		hb, err := dto.DecodeHeartbeat(queueutils.FromDelivery(msg))
+		if err != nil {
+			log.Printf("Failed to decode heartbeat. Error: %s", err.Error())
+			continue
+		}
+
+		_, err = Sensors.ID(hb.Name)
+		switch {
+		case errors.Is(err, ErrUnknownSensor):
+			r.register(hb)
+		case err != nil:
+			// the next heartbeat tries again
+			log.Printf("Failed to look up sensor %v. Error: %s", hb.Name, err.Error())
+		}
+	}
+
+	log.Println("Stopped listening for heartbeats.")
+}
+
+func (r *Registrar) register(hb dto.Heartbeat) {
+	created, err := RegisterSensor(hb)
+	if err != nil {
+		// the next heartbeat tries again
+		log.Printf("Failed to register sensor %v. Error: %s", hb.Name, err.Error())
+		return
+	}
+
+	// another data manager may have been quicker
+	if !created {
+		return
+	}
+
+	log.Printf("Registered sensor %v, serial no %v, safe range %v - %v %v",
+		hb.Name, hb.SerialNo, hb.MinSafeValue, hb.MaxSafeValue, hb.UnitType)
+
+	err = r.broker.Publish(
+		queueutils.SensorRegistrationExchange,  //exchange string,
+		"",                                     //key string,
+		amqp.Publishing{Body: []byte(hb.Name)}) //msg amqp.Publishing)
+	if err != nil {
+		log.Printf("Failed to announce the registration of sensor %v. Error: %s", hb.Name, err.Error())
+	}
+}
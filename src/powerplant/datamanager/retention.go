@@ -0,0 +1,134 @@
+package datamanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// ErrNoRetention is returned by NewRetention for a store that can't roll up or delete readings
+var ErrNoRetention = errors.New("the store has no rollups or retention")
+
+// RetentionPolicy says what Retention keeps
+type RetentionPolicy struct {
+	// Lateness is how late a reading may arrive and still be rolled up with the others of its minute
+	Lateness time.Duration
+	// RawAge is how long the readings themselves are kept once they're rolled up, 0 keeps them for good
+	RawAge time.Duration
+	// SensorRawAge overrides RawAge for the sensors named in it
+	SensorRawAge map[string]time.Duration
+}
+
+func (p RetentionPolicy) rawAge(sensor string) time.Duration {
+	if age, ok := p.SensorRawAge[sensor]; ok {
+		return age
+	}
+
+	return p.RawAge
+}
+
+/*
+Retention keeps sensor_reading from growing without bound:
+1, the readings are rolled up into minutes (min, max, avg and count), once they're Lateness old
+2, the minutes are rolled up into hours, once the hour is complete
+3, the readings older than their sensor's raw age are deleted, the ones that aren't rolled up yet are kept anyway
+
+The rollups are kept for good, the hours are rolled up from the minutes so they don't need the readings.
+*/
+type Retention struct {
+	store  store.RetentionStore
+	policy RetentionPolicy
+}
+
+// NewRetention looks after the store given to Open, if it supports it
+func NewRetention(policy RetentionPolicy) (*Retention, error) {
+	rs, ok := db.(store.RetentionStore)
+	if !ok {
+		return nil, ErrNoRetention
+	}
+
+	return &Retention{store: rs, policy: policy}, nil
+}
+
+// Run runs the retention every interval until ctx is done
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// !!! a failed run is just logged, the next one picks up where it left off
+		if err := r.RunOnce(time.Now()); err != nil {
+			log.Printf("Failed to apply the retention policy. Error: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce rolls up and deletes what's due at now
+func (r *Retention) RunOnce(now time.Time) error {
+	minutes := now.Add(-r.policy.Lateness).Truncate(time.Minute)
+	n, err := r.store.RollUp(store.Minute, minutes)
+	if err != nil {
+		return fmt.Errorf("rolling up minutes: %w", err)
+	}
+	if n > 0 {
+		log.Printf("Rolled up %d minutes of readings up to %s", n, minutes.Format(time.RFC3339))
+	}
+
+	// the minutes may be further along than this run got them, if another run came first
+	minutes, err = r.store.RolledUpTo(store.Minute)
+	if err != nil {
+		return err
+	}
+
+	hours := minutes.Truncate(time.Hour)
+	n, err = r.store.RollUp(store.Hour, hours)
+	if err != nil {
+		return fmt.Errorf("rolling up hours: %w", err)
+	}
+	if n > 0 {
+		log.Printf("Rolled up %d hours of readings up to %s", n, hours.Format(time.RFC3339))
+	}
+
+	return r.deleteReadings(now, minutes)
+}
+
+// deleteReadings deletes the readings that are older than the raw age of their sensor and rolled up,
+// which they are before rolledUpTo
+func (r *Retention) deleteReadings(now, rolledUpTo time.Time) error {
+	sensors, err := db.Sensors()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sensors {
+		age := r.policy.rawAge(s.Name)
+		if age <= 0 {
+			continue
+		}
+
+		before := now.Add(-age)
+		if before.After(rolledUpTo) {
+			before = rolledUpTo
+		}
+
+		n, err := r.store.DeleteReadings(s.ID, before)
+		if err != nil {
+			return fmt.Errorf("deleting the readings of %s: %w", s.Name, err)
+		}
+		if n > 0 {
+			log.Printf("Deleted %d readings of %s taken before %s", n, s.Name, before.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
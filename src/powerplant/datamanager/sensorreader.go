@@ -1,46 +1,55 @@
 package datamanager
 
 import (
-	"errors"
-
 	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/store"
 )
 
 // save data to db
 
-var sensors map[string]int
-
 func SaveReading(reading *dto.SensorMessage) error {
-	if sensors[reading.Name] == 0 {
-		getSensors()
-	}
+	return SaveReadings([]dto.SensorMessage{*reading})
+}
 
-	if sensors[reading.Name] == 0 {
-		return errors.New("Unable to find sensor for name '" + reading.Name + "'")
+// SaveReadings saves either all of the readings or none, store.ErrRejected tells that it's their own fault
+func SaveReadings(readings []dto.SensorMessage) error {
+	rows := make([]store.Reading, len(readings))
+	for i, reading := range readings {
+		id, err := Sensors.ID(reading.Name)
+		if err != nil {
+			return err
+		}
+
+		rows[i] = store.Reading{
+			SensorID: id,
+			Value:    reading.Value,
+			TakenOn:  reading.Timestamp,
+		}
 	}
 
-	q := `
-    INSERT INTO sensor_reading (value, sensor_id, taken_on)
-    VALUES ($1, $2, $3)
-  `
-	_, err := db.Exec(q, reading.Value, sensors[reading.Name], reading.Timestamp)
-	return err
+	return db.SaveReadings(rows)
 }
 
-// get the latest data from database
-func getSensors() {
-	sensors = make(map[string]int)
-	q := `
-    SELECT id, name
-    FROM sensor
-  `
-
-	rows, _ := db.Query(q)
-	for rows.Next() {
-		var id int
-		var name string
-		rows.Scan(&id, &name)
-
-		sensors[name] = id
+// RegisterSensor adds a sensor to the sensor table unless it's there already,
+// created tells whether it was this call that added it
+func RegisterSensor(hb dto.Heartbeat) (created bool, err error) {
+	if err := dto.CheckSensorName(hb.Name); err != nil {
+		return false, err
 	}
+
+	created, err = db.AddSensor(store.Sensor{
+		Name:         hb.Name,
+		SerialNo:     hb.SerialNo,
+		UnitType:     hb.UnitType,
+		MinSafeValue: hb.MinSafeValue,
+		MaxSafeValue: hb.MaxSafeValue,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// the next reading of the sensor finds it in the database
+	Sensors.Invalidate(hb.Name)
+
+	return created, nil
 }
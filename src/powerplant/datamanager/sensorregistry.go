@@ -0,0 +1,121 @@
+package datamanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// ErrUnknownSensor is returned for a sensor that isn't in the sensor table
+var ErrUnknownSensor = errors.New("unknown sensor")
+
+/*
+SensorRegistry maps the names of the sensors to their ids in the sensor table, reading through to the
+store the first time a name is asked for. A name that isn't in the table is remembered as unknown
+for a while too, so a sensor nobody has registered doesn't cost a query for every one of its readings.
+
+Registering a sensor invalidates its name, here by RegisterSensor and in every other data manager
+by the announcement on SensorRegistrationExchange, see ListenForRegistrations.
+*/
+type SensorRegistry struct {
+	sensors    store.SensorStore
+	unknownTTL time.Duration
+
+	mutex      sync.RWMutex
+	ids        map[string]int
+	unknown    map[string]time.Time // when the name was found missing
+	generation uint64               // counts the invalidations, a lookup that one came in during isn't kept
+}
+
+func NewSensorRegistry(sensors store.SensorStore, unknownTTL time.Duration) *SensorRegistry {
+	return &SensorRegistry{
+		sensors:    sensors,
+		unknownTTL: unknownTTL,
+		ids:        make(map[string]int),
+		unknown:    make(map[string]time.Time),
+	}
+}
+
+// ID returns the id of a sensor, the error is ErrUnknownSensor (wrapped) if it isn't in the sensor table,
+// anything else means the store couldn't be asked
+func (r *SensorRegistry) ID(name string) (int, error) {
+	r.mutex.RLock()
+	id, known := r.ids[name]
+	missing, unknown := r.unknown[name]
+	generation := r.generation
+	r.mutex.RUnlock()
+
+	if known {
+		return id, nil
+	}
+	if unknown && time.Since(missing) < r.unknownTTL {
+		return 0, fmt.Errorf("%w '%s'", ErrUnknownSensor, name)
+	}
+
+	sensor, err := r.sensors.SensorByName(name)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// !!! the sensor may have been registered while the store was asked, what it said may be stale already
+	stale := r.generation != generation
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		if !stale {
+			r.unknown[name] = time.Now()
+		}
+		return 0, fmt.Errorf("%w '%s'", ErrUnknownSensor, name)
+	case err != nil:
+		return 0, err
+	}
+
+	if !stale {
+		delete(r.unknown, name)
+		r.ids[name] = sensor.ID
+	}
+	return sensor.ID, nil
+}
+
+// Invalidate makes the next ID of the sensor ask the store again
+func (r *SensorRegistry) Invalidate(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.ids, name)
+	delete(r.unknown, name)
+	r.generation++
+}
+
+// ListenForRegistrations invalidates the sensors announced on SensorRegistrationExchange until ctx is done
+func (r *SensorRegistry) ListenForRegistrations(ctx context.Context, broker queueutils.Broker) {
+	broker.DeclareExchange(queueutils.SensorRegistrationExchange, queueutils.FanoutExchange)
+
+	queueName := queueutils.GetQueue("", broker, true)
+	broker.BindQueue(
+		queueName,                             //queue string,
+		"",                                    //key string,
+		queueutils.SensorRegistrationExchange) //exchange string)
+
+	msgs, err := queueutils.ConsumeContext(ctx, broker,
+		queueName, //queue string,
+		"",        //consumer string,
+		true,      //autoAck bool,
+		false)     //exclusive bool)
+	if err != nil {
+		log.Printf("Failed to listen for sensor registrations. Error: %s", err.Error())
+		return
+	}
+
+	for msg := range msgs {
+		r.Invalidate(string(msg.Body))
+	}
+
+	log.Println("Stopped listening for sensor registrations.")
+}
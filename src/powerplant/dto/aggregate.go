@@ -0,0 +1,197 @@
+package dto
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// AggregateType names Aggregate payloads in their envelopes
+const AggregateType = "Aggregate"
+
+// AggregateSchemaVersion is bumped like SensorMessageSchemaVersion
+const AggregateSchemaVersion = 1
+
+// Aggregate is published by the coordinator for the readings a sensor took in a window, see coordinator.WindowAggregator
+type Aggregate struct {
+	Sensor string
+	Window string    // the name of the window, like "10s"
+	Start  time.Time // of the window, the readings taken from Start up to but not including End are in it
+	End    time.Time
+
+	Count       int
+	Min         float64
+	Max         float64
+	Mean        float64
+	StdDev      float64 // of all the readings in the window, not of a sample of them
+	Percentiles []Percentile
+}
+
+// Percentile is the value Rank percent of the readings of a window are at most, Rank is from 0 to 100
+type Percentile struct {
+	Rank  float64
+	Value float64
+}
+
+func init() {
+	gob.Register(Aggregate{})
+}
+
+// WrapAggregate wraps the aggregate of a window
+func (p *Producer) WrapAggregate(a Aggregate) (Envelope, error) {
+	return p.Wrap(AggregateType, AggregateSchemaVersion, a)
+}
+
+// DecodeAggregate unwraps the aggregate of a window
+func DecodeAggregate(env Envelope) (Aggregate, error) {
+	a := Aggregate{}
+
+	if env.Type != AggregateType {
+		return a, fmt.Errorf("expected a %s, got a '%s'", AggregateType, env.Type)
+	}
+
+	if env.SchemaVersion > AggregateSchemaVersion {
+		return a, fmt.Errorf("%s schema version %d is newer than the supported %d",
+			AggregateType, env.SchemaVersion, AggregateSchemaVersion)
+	}
+
+	err := env.Unwrap(&a)
+	return a, err
+}
+
+// field numbers from sensormessage.proto
+const (
+	aggregateSensorField      = 1
+	aggregateWindowField      = 2
+	aggregateStartField       = 3
+	aggregateEndField         = 4
+	aggregateCountField       = 5
+	aggregateMinField         = 6
+	aggregateMaxField         = 7
+	aggregateMeanField        = 8
+	aggregateStdDevField      = 9
+	aggregatePercentilesField = 10
+
+	percentileRankField  = 1
+	percentileValueField = 2
+)
+
+// MarshalProto encodes the aggregate as the Aggregate of sensormessage.proto
+func (a Aggregate) MarshalProto() ([]byte, error) {
+	b := []byte{}
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   string
+	}{{aggregateSensorField, a.Sensor}, {aggregateWindowField, a.Window}} {
+		b = protowire.AppendTag(b, f.num, protowire.BytesType)
+		b = protowire.AppendString(b, f.v)
+	}
+
+	b = appendTimestamp(b, aggregateStartField, a.Start)
+	b = appendTimestamp(b, aggregateEndField, a.End)
+
+	b = protowire.AppendTag(b, aggregateCountField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(a.Count))
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   float64
+	}{{aggregateMinField, a.Min}, {aggregateMaxField, a.Max}, {aggregateMeanField, a.Mean}, {aggregateStdDevField, a.StdDev}} {
+		b = appendDouble(b, f.num, f.v)
+	}
+
+	for _, p := range a.Percentiles {
+		v := appendDouble(nil, percentileRankField, p.Rank)
+		v = appendDouble(v, percentileValueField, p.Value)
+
+		b = protowire.AppendTag(b, aggregatePercentilesField, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+
+	return b, nil
+}
+
+// UnmarshalProto decodes an Aggregate of sensormessage.proto, skipping the fields it doesn't know
+func (a *Aggregate) UnmarshalProto(data []byte) error {
+	*a = Aggregate{}
+
+	strs := map[protowire.Number]*string{
+		aggregateSensorField: &a.Sensor,
+		aggregateWindowField: &a.Window,
+	}
+	times := map[protowire.Number]*time.Time{
+		aggregateStartField: &a.Start,
+		aggregateEndField:   &a.End,
+	}
+	floats := map[protowire.Number]*float64{
+		aggregateMinField:    &a.Min,
+		aggregateMaxField:    &a.Max,
+		aggregateMeanField:   &a.Mean,
+		aggregateStdDevField: &a.StdDev,
+	}
+
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case strs[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*strs[num] = v
+			return n, nil
+
+		case times[num] != nil && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			*times[num] = time.Unix(seconds, nanos)
+			return n, err
+
+		case num == aggregateCountField && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			a.Count = int(v)
+			return n, nil
+
+		case floats[num] != nil && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(field)
+			*floats[num] = math.Float64frombits(v)
+			return n, nil
+
+		case num == aggregatePercentilesField && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(field)
+			if n < 0 {
+				return n, nil
+			}
+			p, err := consumePercentile(v)
+			a.Percentiles = append(a.Percentiles, p)
+			return n, err
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+}
+
+func consumePercentile(data []byte) (Percentile, error) {
+	p := Percentile{}
+
+	err := walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		if typ == protowire.Fixed64Type && (num == percentileRankField || num == percentileValueField) {
+			v, n := protowire.ConsumeFixed64(field)
+			if num == percentileRankField {
+				p.Rank = math.Float64frombits(v)
+			} else {
+				p.Value = math.Float64frombits(v)
+			}
+			return n, nil
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+
+	return p, err
+}
+
+// appendDouble appends v as a double field
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
@@ -0,0 +1,175 @@
+package dto
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// AlarmTransitionType names AlarmTransition payloads in their envelopes
+const AlarmTransitionType = "AlarmTransition"
+
+// AlarmTransitionSchemaVersion is bumped like SensorMessageSchemaVersion
+const AlarmTransitionSchemaVersion = 1
+
+// AlarmState is how far a sensor is from its safe range
+type AlarmState string
+
+// the states of an alarm, in order of severity
+const (
+	StateNormal  AlarmState = "Normal"
+	StateWarning AlarmState = "Warning" // close to the edge of the safe range
+	StateAlarm   AlarmState = "Alarm"   // outside of the safe range
+)
+
+// Severity orders the states, Normal is 0
+func (s AlarmState) Severity() int {
+	switch s {
+	case StateWarning:
+		return 1
+	case StateAlarm:
+		return 2
+	}
+
+	return 0
+}
+
+// AlarmTransition is published by the coordinator whenever the alarm state of a sensor changes,
+// or the state of one of its rules, see RuleState
+type AlarmTransition struct {
+	Sensor       string
+	Rule         string // empty for the safe range of Sensor, else the rule, Sensor is the one whose reading changed it
+	From         AlarmState
+	To           AlarmState
+	Value        float64 // the reading that made it change
+	MinSafeValue float64 // the safe range the reading was held against
+	MaxSafeValue float64
+	Timestamp    time.Time // of the reading
+}
+
+func init() {
+	gob.Register(AlarmTransition{})
+}
+
+// Alarm names the alarm the transition is of, the sensor for its safe range, or "rule." and the name of the rule,
+// it's the routing key of the transition on AlarmsExchange as well
+func (t AlarmTransition) Alarm() string {
+	if t.Rule != "" {
+		return "rule." + t.Rule
+	}
+
+	return t.Sensor
+}
+
+// WrapAlarmTransition wraps an alarm transition
+func (p *Producer) WrapAlarmTransition(t AlarmTransition) (Envelope, error) {
+	return p.Wrap(AlarmTransitionType, AlarmTransitionSchemaVersion, t)
+}
+
+// DecodeAlarmTransition unwraps an alarm transition
+func DecodeAlarmTransition(env Envelope) (AlarmTransition, error) {
+	t := AlarmTransition{}
+
+	if env.Type != AlarmTransitionType {
+		return t, fmt.Errorf("expected a %s, got a '%s'", AlarmTransitionType, env.Type)
+	}
+
+	if env.SchemaVersion > AlarmTransitionSchemaVersion {
+		return t, fmt.Errorf("%s schema version %d is newer than the supported %d",
+			AlarmTransitionType, env.SchemaVersion, AlarmTransitionSchemaVersion)
+	}
+
+	err := env.Unwrap(&t)
+	return t, err
+}
+
+// field numbers from sensormessage.proto
+const (
+	alarmSensorField       = 1
+	alarmFromField         = 2
+	alarmToField           = 3
+	alarmValueField        = 4
+	alarmMinSafeValueField = 5
+	alarmMaxSafeValueField = 6
+	alarmTimestampField    = 7
+	alarmRuleField         = 8
+)
+
+// MarshalProto encodes the transition as the AlarmTransition of sensormessage.proto
+func (t AlarmTransition) MarshalProto() ([]byte, error) {
+	b := []byte{}
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   string
+	}{{alarmSensorField, t.Sensor}, {alarmFromField, string(t.From)}, {alarmToField, string(t.To)}} {
+		b = protowire.AppendTag(b, f.num, protowire.BytesType)
+		b = protowire.AppendString(b, f.v)
+	}
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   float64
+	}{{alarmValueField, t.Value}, {alarmMinSafeValueField, t.MinSafeValue}, {alarmMaxSafeValueField, t.MaxSafeValue}} {
+		b = protowire.AppendTag(b, f.num, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(f.v))
+	}
+
+	b = appendTimestamp(b, alarmTimestampField, t.Timestamp)
+
+	// left out for the safe ranges, so their transitions stay what they were before there were rules
+	if t.Rule != "" {
+		b = protowire.AppendTag(b, alarmRuleField, protowire.BytesType)
+		b = protowire.AppendString(b, t.Rule)
+	}
+
+	return b, nil
+}
+
+// UnmarshalProto decodes an AlarmTransition of sensormessage.proto, skipping the fields it doesn't know
+func (t *AlarmTransition) UnmarshalProto(data []byte) error {
+	*t = AlarmTransition{}
+
+	strs := map[protowire.Number]*string{
+		alarmSensorField: &t.Sensor,
+		alarmRuleField:   &t.Rule,
+	}
+	states := map[protowire.Number]*AlarmState{
+		alarmFromField: &t.From,
+		alarmToField:   &t.To,
+	}
+	floats := map[protowire.Number]*float64{
+		alarmValueField:        &t.Value,
+		alarmMinSafeValueField: &t.MinSafeValue,
+		alarmMaxSafeValueField: &t.MaxSafeValue,
+	}
+
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case strs[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*strs[num] = v
+			return n, nil
+
+		case states[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*states[num] = AlarmState(v)
+			return n, nil
+
+		case floats[num] != nil && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(field)
+			*floats[num] = math.Float64frombits(v)
+			return n, nil
+
+		case num == alarmTimestampField && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			t.Timestamp = time.Unix(seconds, nanos)
+			return n, err
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+}
@@ -0,0 +1,128 @@
+package dto
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// AlarmActionType names AlarmAction payloads in their envelopes
+const AlarmActionType = "AlarmAction"
+
+// AlarmActionSchemaVersion is bumped like SensorMessageSchemaVersion
+const AlarmActionSchemaVersion = 1
+
+// AlarmActionKind is what an operator does about an alarm
+type AlarmActionKind string
+
+// the actions on an alarm
+const (
+	AckAction      AlarmActionKind = "ack"      // the operator has seen it, until it gets worse or is raised again
+	ShelveAction   AlarmActionKind = "shelve"   // the operator doesn't want to hear about it until ShelvedUntil
+	UnshelveAction AlarmActionKind = "unshelve" // before ShelvedUntil
+	CommentAction  AlarmActionKind = "comment"  // only the comment
+)
+
+// AlarmAction is published by the web applications whenever an operator acknowledges, shelves or comments on an alarm
+type AlarmAction struct {
+	Alarm        string // see AlarmTransition.Alarm
+	Kind         AlarmActionKind
+	Operator     string // who did it
+	Comment      string
+	ShelvedUntil time.Time // zero unless Kind is ShelveAction
+	Timestamp    time.Time
+}
+
+func init() {
+	gob.Register(AlarmAction{})
+}
+
+// WrapAlarmAction wraps an action on an alarm
+func (p *Producer) WrapAlarmAction(a AlarmAction) (Envelope, error) {
+	return p.Wrap(AlarmActionType, AlarmActionSchemaVersion, a)
+}
+
+// DecodeAlarmAction unwraps an action on an alarm
+func DecodeAlarmAction(env Envelope) (AlarmAction, error) {
+	a := AlarmAction{}
+
+	if env.Type != AlarmActionType {
+		return a, fmt.Errorf("expected a %s, got a '%s'", AlarmActionType, env.Type)
+	}
+
+	if env.SchemaVersion > AlarmActionSchemaVersion {
+		return a, fmt.Errorf("%s schema version %d is newer than the supported %d",
+			AlarmActionType, env.SchemaVersion, AlarmActionSchemaVersion)
+	}
+
+	err := env.Unwrap(&a)
+	return a, err
+}
+
+// field numbers from sensormessage.proto
+const (
+	actionAlarmField        = 1
+	actionKindField         = 2
+	actionOperatorField     = 3
+	actionCommentField      = 4
+	actionShelvedUntilField = 5
+	actionTimestampField    = 6
+)
+
+// MarshalProto encodes the action as the AlarmAction of sensormessage.proto
+func (a AlarmAction) MarshalProto() ([]byte, error) {
+	b := []byte{}
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   string
+	}{{actionAlarmField, a.Alarm}, {actionKindField, string(a.Kind)}, {actionOperatorField, a.Operator}, {actionCommentField, a.Comment}} {
+		b = protowire.AppendTag(b, f.num, protowire.BytesType)
+		b = protowire.AppendString(b, f.v)
+	}
+
+	if !a.ShelvedUntil.IsZero() {
+		b = appendTimestamp(b, actionShelvedUntilField, a.ShelvedUntil)
+	}
+	b = appendTimestamp(b, actionTimestampField, a.Timestamp)
+
+	return b, nil
+}
+
+// UnmarshalProto decodes an AlarmAction of sensormessage.proto, skipping the fields it doesn't know
+func (a *AlarmAction) UnmarshalProto(data []byte) error {
+	*a = AlarmAction{}
+
+	strs := map[protowire.Number]*string{
+		actionAlarmField:    &a.Alarm,
+		actionOperatorField: &a.Operator,
+		actionCommentField:  &a.Comment,
+	}
+	times := map[protowire.Number]*time.Time{
+		actionShelvedUntilField: &a.ShelvedUntil,
+		actionTimestampField:    &a.Timestamp,
+	}
+
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case strs[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*strs[num] = v
+			return n, nil
+
+		case num == actionKindField && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			a.Kind = AlarmActionKind(v)
+			return n, nil
+
+		case times[num] != nil && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			*times[num] = time.Unix(seconds, nanos)
+			return n, err
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+}
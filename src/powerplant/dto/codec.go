@@ -0,0 +1,148 @@
+package dto
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sync"
+)
+
+// content types of the codecs that come with the plant
+const (
+	// GobContentType is what the plant has always been sending, a message without a content type is gob, too
+	GobContentType = "application/x-gob"
+	// JSONContentType and ProtobufContentType can be read by tools that aren't written in Go,
+	// see sensormessage.proto for the protobuf schema
+	JSONContentType     = "application/json"
+	ProtobufContentType = "application/x-protobuf"
+)
+
+// codecAliases are the short names the configuration and flags can use instead of a content type
+var codecAliases = map[string]string{
+	"gob":      GobContentType,
+	"json":     JSONContentType,
+	"protobuf": ProtobufContentType,
+}
+
+// Codec turns messages into bytes and back for one content type
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byContentType map[string]Codec
+}{byContentType: make(map[string]Codec)}
+
+func init() {
+	RegisterCodec(gobCodec{})
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(protobufCodec{})
+}
+
+// RegisterCodec makes the codec available to producers and consumers, replacing any codec for the same content type
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	codecs.byContentType[c.ContentType()] = c
+}
+
+// CodecFor returns the codec for a content type, parameters like "; charset=utf-8" are ignored,
+// and the short names "gob", "json" and "protobuf" are understood as well
+func CodecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		contentType = GobContentType
+	}
+
+	if alias, ok := codecAliases[contentType]; ok {
+		contentType = alias
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type '%s': %s", contentType, err)
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	c, ok := codecs.byContentType[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("no codec for content type '%s'", contentType)
+	}
+
+	return c, nil
+}
+
+// gobCodec needs a new encoder for every message, so every payload carries the type descriptor
+// and can be decoded on its own, which also makes gob the most verbose of the codecs
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return GobContentType
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := gob.NewEncoder(buffer).Encode(v)
+
+	return buffer.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return JSONContentType
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtoMessage is implemented by the messages that can be sent as protobuf,
+// they encode themselves since the plant doesn't use generated code
+type ProtoMessage interface {
+	MarshalProto() ([]byte, error)
+}
+
+// ProtoUnmarshaler is the decoding side of ProtoMessage
+type ProtoUnmarshaler interface {
+	UnmarshalProto(data []byte) error
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ProtobufContentType
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(ProtoMessage)
+	if !ok {
+		return nil, fmt.Errorf("%T can't be encoded as protobuf", v)
+	}
+
+	return m.MarshalProto()
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(ProtoUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T can't be decoded from protobuf", v)
+	}
+
+	return m.UnmarshalProto(data)
+}
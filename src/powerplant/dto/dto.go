@@ -2,6 +2,8 @@ package dto
 
 import (
 	"encoding/gob"
+	"errors"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,19 @@ type SensorMessage struct {
 	Timestamp time.Time
 }
 
-func int() {
+func init() {
 	gob.Register(SensorMessage{})
 }
+
+// CheckSensorName tells whether name can be the name of a sensor, it's the name of its queue and a word of the
+// event topics and routing keys of its readings, reading.{name} for one, so it mustn't have '.', '*' or '#' in it
+func CheckSensorName(name string) error {
+	if name == "" {
+		return errors.New("the name of a sensor must not be empty")
+	}
+	if strings.ContainsAny(name, ".*#") {
+		return errors.New("the name of a sensor must not have '.', '*' or '#' in it: " + name)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package dto
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SensorMessageType names SensorMessage payloads in their envelopes
+const SensorMessageType = "SensorMessage"
+
+// SensorMessageSchemaVersion is bumped whenever a field of SensorMessage is renamed, removed or changes meaning,
+// adding a field doesn't need a new version since every codec skips the fields it doesn't know
+const SensorMessageSchemaVersion = 1
+
+/*
+Envelope is what actually travels through the broker, an encoded message plus what a consumer needs to
+make sense of it: how it's encoded (ContentType), what it is (Type, SchemaVersion) and where it comes from
+(ProducerID, Sequence, MessageID), so consumers can detect gaps and duplicates.
+
+queueutils maps the envelope onto the AMQP message properties.
+*/
+type Envelope struct {
+	SchemaVersion int
+	ContentType   string
+	Type          string
+	MessageID     string
+	ProducerID    string
+	Sequence      uint64
+	Timestamp     time.Time
+	Body          []byte
+}
+
+// Producer wraps the messages of one producer into envelopes, numbering them in order
+type Producer struct {
+	id    string
+	codec Codec
+	mutex sync.Mutex
+	seq   uint64
+}
+
+// NewProducer returns a producer encoding its messages with the codec registered for contentType
+func NewProducer(id, contentType string) (*Producer, error) {
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer{id: id, codec: codec}, nil
+}
+
+// ProducerID makes up an ID for the process playing role, unique enough to tell producers apart in the logs
+func ProducerID(role string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s@%s:%d", role, host, os.Getpid())
+}
+
+// ID of the producer
+func (p *Producer) ID() string {
+	return p.id
+}
+
+// Wrap encodes v and puts it in an envelope with the next sequence number
+func (p *Producer) Wrap(msgType string, schemaVersion int, v interface{}) (Envelope, error) {
+	body, err := p.codec.Marshal(v)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	p.mutex.Lock()
+	p.seq++
+	seq := p.seq
+	p.mutex.Unlock()
+
+	return Envelope{
+		SchemaVersion: schemaVersion,
+		ContentType:   p.codec.ContentType(),
+		Type:          msgType,
+		MessageID:     newMessageID(),
+		ProducerID:    p.id,
+		Sequence:      seq,
+		Timestamp:     time.Now(),
+		Body:          body,
+	}, nil
+}
+
+// WrapSensorMessage wraps a reading
+func (p *Producer) WrapSensorMessage(msg SensorMessage) (Envelope, error) {
+	return p.Wrap(SensorMessageType, SensorMessageSchemaVersion, msg)
+}
+
+// Unwrap decodes the body into v with the codec for the envelope's content type
+func (env Envelope) Unwrap(v interface{}) error {
+	codec, err := CodecFor(env.ContentType)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(env.Body, v)
+}
+
+// DecodeSensorMessage unwraps a reading, envelopes from producers older than the envelope
+// (no type, schema version 0) are taken to be gob encoded readings
+func DecodeSensorMessage(env Envelope) (SensorMessage, error) {
+	msg := SensorMessage{}
+
+	if env.Type != "" && env.Type != SensorMessageType {
+		return msg, fmt.Errorf("expected a %s, got a %s", SensorMessageType, env.Type)
+	}
+
+	if env.SchemaVersion > SensorMessageSchemaVersion {
+		return msg, fmt.Errorf("%s schema version %d is newer than the supported %d",
+			SensorMessageType, env.SchemaVersion, SensorMessageSchemaVersion)
+	}
+
+	err := env.Unwrap(&msg)
+	return msg, err
+}
+
+func newMessageID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,173 @@
+package dto
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// HeartbeatType names Heartbeat payloads in their envelopes
+const HeartbeatType = "Heartbeat"
+
+// HeartbeatSchemaVersion is bumped like SensorMessageSchemaVersion
+const HeartbeatSchemaVersion = 1
+
+// Heartbeat is sent by every sensor at a fixed interval, whatever its reading frequency,
+// it tells the coordinator the sensor is alive and how it's set up
+type Heartbeat struct {
+	Name      string
+	Version   string  // of the sensor program
+	Frequency uint    // readings per second
+	Min       float64 // range of the generated readings
+	Max       float64
+	Step      float64 // maximum change per reading
+	Host      string
+	Uptime    time.Duration // since the sensor started
+	Interval  time.Duration // until the next heartbeat
+	Timestamp time.Time
+
+	// what the plant's sensor table holds about the sensor, used to register it when it isn't there yet
+	SerialNo     string
+	UnitType     string
+	MinSafeValue float64
+	MaxSafeValue float64
+}
+
+func init() {
+	gob.Register(Heartbeat{})
+}
+
+// WrapHeartbeat wraps a heartbeat
+func (p *Producer) WrapHeartbeat(hb Heartbeat) (Envelope, error) {
+	return p.Wrap(HeartbeatType, HeartbeatSchemaVersion, hb)
+}
+
+// DecodeHeartbeat unwraps a heartbeat
+func DecodeHeartbeat(env Envelope) (Heartbeat, error) {
+	hb := Heartbeat{}
+
+	if env.Type != HeartbeatType {
+		return hb, fmt.Errorf("expected a %s, got a '%s'", HeartbeatType, env.Type)
+	}
+
+	if env.SchemaVersion > HeartbeatSchemaVersion {
+		return hb, fmt.Errorf("%s schema version %d is newer than the supported %d",
+			HeartbeatType, env.SchemaVersion, HeartbeatSchemaVersion)
+	}
+
+	err := env.Unwrap(&hb)
+	return hb, err
+}
+
+// field numbers from sensormessage.proto
+const (
+	heartbeatNameField      = 1
+	heartbeatVersionField   = 2
+	heartbeatFrequencyField = 3
+	heartbeatMinField       = 4
+	heartbeatMaxField       = 5
+	heartbeatStepField      = 6
+	heartbeatHostField      = 7
+	heartbeatUptimeField    = 8
+	heartbeatIntervalField  = 9
+	heartbeatTimestampField = 10
+
+	heartbeatSerialNoField     = 11
+	heartbeatUnitTypeField     = 12
+	heartbeatMinSafeValueField = 13
+	heartbeatMaxSafeValueField = 14
+)
+
+// MarshalProto encodes the heartbeat as the Heartbeat of sensormessage.proto
+func (hb Heartbeat) MarshalProto() ([]byte, error) {
+	b := []byte{}
+
+	b = protowire.AppendTag(b, heartbeatNameField, protowire.BytesType)
+	b = protowire.AppendString(b, hb.Name)
+	b = protowire.AppendTag(b, heartbeatVersionField, protowire.BytesType)
+	b = protowire.AppendString(b, hb.Version)
+	b = protowire.AppendTag(b, heartbeatFrequencyField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(hb.Frequency))
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   float64
+	}{{heartbeatMinField, hb.Min}, {heartbeatMaxField, hb.Max}, {heartbeatStepField, hb.Step},
+		{heartbeatMinSafeValueField, hb.MinSafeValue}, {heartbeatMaxSafeValueField, hb.MaxSafeValue}} {
+		b = protowire.AppendTag(b, f.num, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(f.v))
+	}
+
+	b = protowire.AppendTag(b, heartbeatHostField, protowire.BytesType)
+	b = protowire.AppendString(b, hb.Host)
+	b = appendDuration(b, heartbeatUptimeField, hb.Uptime)
+	b = appendDuration(b, heartbeatIntervalField, hb.Interval)
+	b = appendTimestamp(b, heartbeatTimestampField, hb.Timestamp)
+
+	b = protowire.AppendTag(b, heartbeatSerialNoField, protowire.BytesType)
+	b = protowire.AppendString(b, hb.SerialNo)
+	b = protowire.AppendTag(b, heartbeatUnitTypeField, protowire.BytesType)
+	b = protowire.AppendString(b, hb.UnitType)
+
+	return b, nil
+}
+
+// UnmarshalProto decodes a Heartbeat of sensormessage.proto, skipping the fields it doesn't know
+func (hb *Heartbeat) UnmarshalProto(data []byte) error {
+	*hb = Heartbeat{}
+
+	strs := map[protowire.Number]*string{
+		heartbeatNameField:    &hb.Name,
+		heartbeatVersionField: &hb.Version,
+		heartbeatHostField:    &hb.Host,
+
+		heartbeatSerialNoField: &hb.SerialNo,
+		heartbeatUnitTypeField: &hb.UnitType,
+	}
+	floats := map[protowire.Number]*float64{
+		heartbeatMinField:  &hb.Min,
+		heartbeatMaxField:  &hb.Max,
+		heartbeatStepField: &hb.Step,
+
+		heartbeatMinSafeValueField: &hb.MinSafeValue,
+		heartbeatMaxSafeValueField: &hb.MaxSafeValue,
+	}
+	durations := map[protowire.Number]*time.Duration{
+		heartbeatUptimeField:   &hb.Uptime,
+		heartbeatIntervalField: &hb.Interval,
+	}
+
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case strs[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*strs[num] = v
+			return n, nil
+
+		case floats[num] != nil && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(field)
+			*floats[num] = math.Float64frombits(v)
+			return n, nil
+
+		case num == heartbeatFrequencyField && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			hb.Frequency = uint(v)
+			return n, nil
+
+		case durations[num] != nil && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			*durations[num] = time.Duration(seconds)*time.Second + time.Duration(nanos)
+			return n, err
+
+		case num == heartbeatTimestampField && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			hb.Timestamp = time.Unix(seconds, nanos)
+			return n, err
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+}
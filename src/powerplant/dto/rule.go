@@ -0,0 +1,133 @@
+package dto
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RuleStateType names RuleState payloads in their envelopes
+const RuleStateType = "RuleState"
+
+// RuleStateSchemaVersion is bumped like SensorMessageSchemaVersion
+const RuleStateSchemaVersion = 1
+
+// RuleState is sent to the web applications whenever a rule of the coordinator is loaded, fires or resolves,
+// and for every rule when a web application asks for the sources
+type RuleState struct {
+	Name        string
+	Expression  string // the condition, see coordinator.RuleEngine
+	Description string
+	Severity    AlarmState // the state the rule is in while it fires, Warning or Alarm
+	State       AlarmState // Normal while it's resolved
+	Since       time.Time  // of the reading that changed State, zero if it never has
+	Removed     bool       // the rule isn't in the rules file any more
+}
+
+// Firing tells whether the condition of the rule holds
+func (rs RuleState) Firing() bool {
+	return rs.State.Severity() > StateNormal.Severity()
+}
+
+func init() {
+	gob.Register(RuleState{})
+}
+
+// WrapRuleState wraps the state of a rule
+func (p *Producer) WrapRuleState(rs RuleState) (Envelope, error) {
+	return p.Wrap(RuleStateType, RuleStateSchemaVersion, rs)
+}
+
+// DecodeRuleState unwraps the state of a rule
+func DecodeRuleState(env Envelope) (RuleState, error) {
+	rs := RuleState{}
+
+	if env.Type != RuleStateType {
+		return rs, fmt.Errorf("expected a %s, got a '%s'", RuleStateType, env.Type)
+	}
+
+	if env.SchemaVersion > RuleStateSchemaVersion {
+		return rs, fmt.Errorf("%s schema version %d is newer than the supported %d",
+			RuleStateType, env.SchemaVersion, RuleStateSchemaVersion)
+	}
+
+	err := env.Unwrap(&rs)
+	return rs, err
+}
+
+// field numbers from sensormessage.proto
+const (
+	ruleNameField        = 1
+	ruleExpressionField  = 2
+	ruleDescriptionField = 3
+	ruleSeverityField    = 4
+	ruleStateField       = 5
+	ruleSinceField       = 6
+	ruleRemovedField     = 7
+)
+
+// MarshalProto encodes the state as the RuleState of sensormessage.proto
+func (rs RuleState) MarshalProto() ([]byte, error) {
+	b := []byte{}
+
+	for _, f := range []struct {
+		num protowire.Number
+		v   string
+	}{{ruleNameField, rs.Name}, {ruleExpressionField, rs.Expression}, {ruleDescriptionField, rs.Description},
+		{ruleSeverityField, string(rs.Severity)}, {ruleStateField, string(rs.State)}} {
+		b = protowire.AppendTag(b, f.num, protowire.BytesType)
+		b = protowire.AppendString(b, f.v)
+	}
+
+	if !rs.Since.IsZero() {
+		b = appendTimestamp(b, ruleSinceField, rs.Since)
+	}
+
+	b = protowire.AppendTag(b, ruleRemovedField, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(rs.Removed))
+
+	return b, nil
+}
+
+// UnmarshalProto decodes a RuleState of sensormessage.proto, skipping the fields it doesn't know
+func (rs *RuleState) UnmarshalProto(data []byte) error {
+	*rs = RuleState{}
+
+	strs := map[protowire.Number]*string{
+		ruleNameField:        &rs.Name,
+		ruleExpressionField:  &rs.Expression,
+		ruleDescriptionField: &rs.Description,
+	}
+	states := map[protowire.Number]*AlarmState{
+		ruleSeverityField: &rs.Severity,
+		ruleStateField:    &rs.State,
+	}
+
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case strs[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*strs[num] = v
+			return n, nil
+
+		case states[num] != nil && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			*states[num] = AlarmState(v)
+			return n, nil
+
+		case num == ruleSinceField && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			rs.Since = time.Unix(seconds, nanos)
+			return n, err
+
+		case num == ruleRemovedField && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			rs.Removed = protowire.DecodeBool(v)
+			return n, nil
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+}
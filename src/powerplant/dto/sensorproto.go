@@ -0,0 +1,131 @@
+package dto
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field numbers from sensormessage.proto
+const (
+	sensorNameField      = 1
+	sensorValueField     = 2
+	sensorTimestampField = 3
+
+	// google.protobuf.Timestamp and google.protobuf.Duration
+	secondsField = 1
+	nanosField   = 2
+)
+
+// MarshalProto encodes the reading as the SensorMessage of sensormessage.proto
+func (m SensorMessage) MarshalProto() ([]byte, error) {
+	b := []byte{}
+
+	b = protowire.AppendTag(b, sensorNameField, protowire.BytesType)
+	b = protowire.AppendString(b, m.Name)
+
+	b = protowire.AppendTag(b, sensorValueField, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(m.Value))
+
+	b = appendTimestamp(b, sensorTimestampField, m.Timestamp)
+
+	return b, nil
+}
+
+// UnmarshalProto decodes a SensorMessage of sensormessage.proto, skipping the fields it doesn't know
+func (m *SensorMessage) UnmarshalProto(data []byte) error {
+	*m = SensorMessage{}
+
+	return walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case num == sensorNameField && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(field)
+			m.Name = v
+			return n, nil
+
+		case num == sensorValueField && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(field)
+			m.Value = math.Float64frombits(v)
+			return n, nil
+
+		case num == sensorTimestampField && typ == protowire.BytesType:
+			seconds, nanos, n, err := consumeSecondsNanos(field)
+			m.Timestamp = time.Unix(seconds, nanos)
+			return n, err
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+}
+
+// appendTimestamp appends t as a google.protobuf.Timestamp
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	return appendSecondsNanos(b, num, t.Unix(), int64(t.Nanosecond()))
+}
+
+// appendDuration appends d as a google.protobuf.Duration
+func appendDuration(b []byte, num protowire.Number, d time.Duration) []byte {
+	return appendSecondsNanos(b, num, int64(d/time.Second), int64(d%time.Second))
+}
+
+func appendSecondsNanos(b []byte, num protowire.Number, seconds, nanos int64) []byte {
+	v := []byte{}
+	v = protowire.AppendTag(v, secondsField, protowire.VarintType)
+	v = protowire.AppendVarint(v, uint64(seconds))
+	v = protowire.AppendTag(v, nanosField, protowire.VarintType)
+	v = protowire.AppendVarint(v, uint64(nanos))
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// consumeSecondsNanos reads a google.protobuf.Timestamp or Duration, n is how many bytes of field it took
+func consumeSecondsNanos(field []byte) (seconds, nanos int64, n int, err error) {
+	v, n := protowire.ConsumeBytes(field)
+	if n < 0 {
+		return 0, 0, n, nil
+	}
+
+	err = walkProto(v, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		if typ == protowire.VarintType && (num == secondsField || num == nanosField) {
+			v, n := protowire.ConsumeVarint(field)
+			if num == secondsField {
+				seconds = int64(v)
+			} else {
+				nanos = int64(int32(v))
+			}
+			return n, nil
+		}
+
+		return protowire.ConsumeFieldValue(num, typ, field), nil
+	})
+
+	return seconds, nanos, n, err
+}
+
+// walkProto calls field for every field in data, field returns how many bytes of the value it consumed
+func walkProto(data []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if n > len(data) {
+			return errors.New("truncated protobuf field")
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
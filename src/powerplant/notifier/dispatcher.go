@@ -0,0 +1,190 @@
+package notifier
+
+import (
+	"context"
+	"log"
+	"path"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
+
+// what a sink gets unless its settings say otherwise
+const (
+	defaultMaxAttempts = 5
+	defaultTimeout     = 10 * time.Second
+)
+
+// DispatchQueueSize is how many notifications may wait for a sink, the ones beyond are left out
+const DispatchQueueSize = 100
+
+// sinkBackoff is waited between the attempts of a sink
+var sinkBackoff = queueutils.Backoff{Initial: time.Second, Max: time.Minute}
+
+// pruneSize is how many transitions a dispatcher remembers for the dedup before it forgets the old ones
+const pruneSize = 1000
+
+/*
+dispatcher sends the notifications of one sink, offer picks the ones the sink gets and queues them, run sends
+them one at a time. offer is only called by the goroutine of the Notifier, so what it keeps needs no lock.
+*/
+type dispatcher struct {
+	settings config.NotifierSink
+	sink     Sink
+	queue    chan Notification
+
+	sent       map[dedupKey]time.Time // when the alarm went to the state last, of the notifications queued
+	limiter    rateLimiter
+	suppressed int // left out since the one queued last
+}
+
+type dedupKey struct {
+	alarm string
+	to    string
+}
+
+func newDispatcher(settings config.NotifierSink, sink Sink) *dispatcher {
+	if settings.MinSeverity == "" {
+		settings.MinSeverity = dto.StateWarning
+	}
+	if settings.MaxAttempts == 0 {
+		settings.MaxAttempts = defaultMaxAttempts
+	}
+	if settings.Timeout == 0 {
+		settings.Timeout = defaultTimeout
+	}
+
+	return &dispatcher{
+		settings: settings,
+		sink:     sink,
+		queue:    make(chan Notification, DispatchQueueSize),
+		sent:     make(map[dedupKey]time.Time),
+		limiter:  rateLimiter{limit: settings.RateLimit, period: settings.RatePeriod},
+	}
+}
+
+// wants tells whether the sink is interested in the alarm and the severity of n
+func (d *dispatcher) wants(n Notification) bool {
+	if len(d.settings.Alarms) > 0 {
+		matched := false
+		for _, pattern := range d.settings.Alarms {
+			if ok, _ := path.Match(pattern, n.Alarm); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	min := d.settings.MinSeverity.Severity()
+	return dto.AlarmState(n.From).Severity() >= min || dto.AlarmState(n.To).Severity() >= min
+}
+
+// offer queues n for the sink unless it's left out
+func (d *dispatcher) offer(n Notification, now time.Time) {
+	if !d.wants(n) {
+		return
+	}
+
+	key := dedupKey{alarm: n.Alarm, to: n.To}
+	if last, ok := d.sent[key]; ok && !n.Timestamp.After(last.Add(d.settings.Dedup)) {
+		return
+	}
+
+	if !d.limiter.allow(now) {
+		d.suppressed++
+		log.Printf("Left out the notification of %s for %s, over the rate limit", n.Alarm, d.settings.Name)
+		return
+	}
+
+	n.Suppressed = d.suppressed
+	select {
+	case d.queue <- n:
+		d.suppressed = 0
+		d.remember(key, n.Timestamp)
+	default:
+		d.suppressed++
+		log.Printf("Left out the notification of %s for %s, %d are waiting already", n.Alarm, d.settings.Name, DispatchQueueSize)
+	}
+}
+
+// remember keeps when the alarm went to the state for the dedup, forgetting the ones it no longer needs
+func (d *dispatcher) remember(key dedupKey, at time.Time) {
+	d.sent[key] = at
+
+	if len(d.sent) > pruneSize {
+		for k, t := range d.sent {
+			if at.Sub(t) > d.settings.Dedup {
+				delete(d.sent, k)
+			}
+		}
+	}
+}
+
+// run sends the queued notifications until the queue is closed, ctx cuts short the one being sent
+func (d *dispatcher) run(ctx context.Context) {
+	for n := range d.queue {
+		d.send(ctx, n)
+	}
+}
+
+func (d *dispatcher) send(ctx context.Context, n Notification) {
+	for attempt := 0; ; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, d.settings.Timeout)
+		err := d.sink.Send(sendCtx, n)
+		cancel()
+
+		if err == nil {
+			return
+		}
+		if isPermanent(err) || attempt+1 >= d.settings.MaxAttempts || ctx.Err() != nil {
+			log.Printf("Failed to notify %s of %s, giving up after %d attempts. Error: %s", d.settings.Name, n.Alarm, attempt+1, err)
+			return
+		}
+
+		delay := sinkBackoff.Delay(attempt)
+		log.Printf("Failed to notify %s of %s (attempt %d), trying again in %v. Error: %s", d.settings.Name, n.Alarm, attempt+1, delay, err)
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Failed to notify %s of %s, stopped. Error: %s", d.settings.Name, n.Alarm, err)
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// rateLimiter is a token bucket of limit tokens refilled over period, 0 lets everything through,
+// period has to be positive with a limit, see config.NotifierSink.Validate
+type rateLimiter struct {
+	limit  int
+	period time.Duration
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) allow(now time.Time) bool {
+	if l.limit == 0 {
+		return true
+	}
+
+	if l.last.IsZero() {
+		l.tokens = float64(l.limit)
+	} else if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += float64(l.limit) * float64(elapsed) / float64(l.period)
+		if l.tokens > float64(l.limit) {
+			l.tokens = float64(l.limit)
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
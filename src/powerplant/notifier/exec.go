@@ -0,0 +1,75 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+)
+
+// execSink runs a command for every notification, with the notification as JSON on its standard input
+// and in POWERPLANT_ALARM, POWERPLANT_ALARM_FROM, POWERPLANT_ALARM_TO and POWERPLANT_ALARM_SUMMARY
+type execSink struct {
+	command []*template.Template
+}
+
+func newExecSink(settings config.Exec) (*execSink, error) {
+	s := execSink{}
+	for i, arg := range settings.Command {
+		t, err := parseTemplate(fmt.Sprintf("exec.command[%d]", i), arg, "")
+		if err != nil {
+			return nil, err
+		}
+		s.command = append(s.command, t)
+	}
+
+	return &s, nil
+}
+
+func (s *execSink) Send(ctx context.Context, n Notification) error {
+	args := make([]string, len(s.command))
+	for i, t := range s.command {
+		var err error
+		if args[i], err = execute(t, n); err != nil {
+			return err
+		}
+	}
+
+	input, err := json.Marshal(n)
+	if err != nil {
+		return permanent(err)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"POWERPLANT_ALARM="+n.Alarm,
+		"POWERPLANT_ALARM_FROM="+n.From,
+		"POWERPLANT_ALARM_TO="+n.To,
+		"POWERPLANT_ALARM_SUMMARY="+n.Summary())
+
+	output, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return permanent(err)
+	}
+	if err != nil {
+		// the end of what it said is usually why
+		out := strings.TrimSpace(string(output))
+		if len(out) > 200 {
+			out = "..." + out[len(out)-200:]
+		}
+		if out != "" {
+			return fmt.Errorf("%s: %s: %s", args[0], err, out)
+		}
+		return fmt.Errorf("%s: %s", args[0], err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/notifier"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
+
+func main() {
+	cfg := config.MustLoad()
+
+	// runs until it's interrupted or terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// main mock runs a receiver for the webhooks and one for the mails instead, to point the sinks at
+	if flag.Arg(0) == "mock" {
+		runMock(ctx)
+		return
+	}
+
+	if len(cfg.Notifier.Sinks) == 0 {
+		log.Fatalln("No notifier.sinks configured, there's nobody to notify.")
+	}
+
+	n, err := notifier.New(cfg.Notifier.Sinks)
+	if err != nil {
+		log.Fatalf("Failed to set up the sinks: %s", err)
+	}
+
+	broker := queueutils.GetBroker(cfg.AMQP.URL)
+	defer broker.Close()
+
+	// the transitions of the alarms go to the queue even while no notifier is running, the DLQ only gets the ones
+	// that don't decode, so the retry delay is the data manager's for want of another
+	runErr := n.Run(ctx, broker, cfg.Datamanager.RetryDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	if err := n.Close(shutdownCtx); err != nil {
+		log.Printf("Stopped before every notification was sent: %s", err)
+	}
+
+	if runErr != nil {
+		log.Fatalf("Notifier stopped: %s", runErr)
+	}
+
+	log.Println("Notifier stopped")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/notifier/mockreceiver"
+)
+
+// runMock is the mock subcommand, it prints what the receivers get until ctx is done
+func runMock(ctx context.Context) {
+	web := mockreceiver.NewHTTPReceiver()
+	defer web.Close()
+
+	mail, err := mockreceiver.NewSMTPReceiver()
+	if err != nil {
+		log.Fatalf("Failed to start the SMTP receiver: %s", err)
+	}
+	defer mail.Close()
+
+	log.Printf("Receiving webhooks at %s and mails at %s", web.URL(), mail.Addr())
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	requests, mails := 0, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, r := range web.Requests()[requests:] {
+			log.Printf("%s %s\n%s", r.Method, r.Path, r.Body)
+			requests++
+		}
+		for _, m := range mail.Mails()[mails:] {
+			log.Printf("Mail from %s to %v: %s\n%s", m.From, m.To, m.Subject, m.Body)
+			mails++
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+mockreceiver has an HTTP and an SMTP server that keep whatever they get, to try the sinks of the notifier without
+a real endpoint or mail server, in tests or with "main mock" of the notifier executor. They listen on 127.0.0.1 only.
+*/
+package mockreceiver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Request is a request the HTTP receiver got
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// HTTPReceiver answers every request with 204, or with the status given to FailNext
+type HTTPReceiver struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	requests []Request
+	failures []int // the statuses of the next requests
+	received chan struct{}
+}
+
+// NewHTTPReceiver starts an HTTP receiver, Close stops it
+func NewHTTPReceiver() *HTTPReceiver {
+	r := HTTPReceiver{received: make(chan struct{}, 1)}
+	r.server = httptest.NewServer(http.HandlerFunc(r.handle))
+	return &r
+}
+
+func (r *HTTPReceiver) handle(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mutex.Lock()
+	r.requests = append(r.requests, Request{
+		Method: req.Method,
+		Path:   req.URL.RequestURI(),
+		Header: req.Header.Clone(),
+		Body:   string(body),
+	})
+	status := http.StatusNoContent
+	if len(r.failures) > 0 {
+		status = r.failures[0]
+		r.failures = r.failures[1:]
+	}
+	r.mutex.Unlock()
+
+	notify(r.received)
+	w.WriteHeader(status)
+}
+
+// URL is where the receiver listens, like http://127.0.0.1:40123
+func (r *HTTPReceiver) URL() string {
+	return r.server.URL
+}
+
+// FailNext answers the next requests with statuses, one each, they're kept all the same
+func (r *HTTPReceiver) FailNext(statuses ...int) {
+	r.mutex.Lock()
+	r.failures = append(r.failures, statuses...)
+	r.mutex.Unlock()
+}
+
+// Requests returns the requests so far
+func (r *HTTPReceiver) Requests() []Request {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Request(nil), r.requests...)
+}
+
+// Wait returns the requests once there are at least n, or fails after timeout
+func (r *HTTPReceiver) Wait(n int, timeout time.Duration) ([]Request, error) {
+	deadline := time.After(timeout)
+	for {
+		if requests := r.Requests(); len(requests) >= n {
+			return requests, nil
+		}
+
+		select {
+		case <-r.received:
+		case <-deadline:
+			return r.Requests(), fmt.Errorf("expected %d requests, got %d", n, len(r.Requests()))
+		}
+	}
+}
+
+// Close stops the receiver
+func (r *HTTPReceiver) Close() {
+	r.server.Close()
+}
+
+// notify wakes up a Wait, if there's none waiting the next one looks again anyway
+func notify(received chan struct{}) {
+	select {
+	case received <- struct{}{}:
+	default:
+	}
+}
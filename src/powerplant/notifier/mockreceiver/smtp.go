@@ -0,0 +1,224 @@
+package mockreceiver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail is a mail the SMTP receiver got
+type Mail struct {
+	Username string // of AUTH PLAIN, empty without it
+	From     string
+	To       []string
+	Subject  string
+	Body     string
+	Data     string // all of it, headers and body
+}
+
+/*
+SMTPReceiver speaks just enough SMTP for net/smtp: EHLO, AUTH PLAIN (any password will do), MAIL, RCPT, DATA,
+RSET, NOOP and QUIT. It doesn't offer STARTTLS.
+*/
+type SMTPReceiver struct {
+	listener net.Listener
+	conns    sync.WaitGroup
+
+	mutex    sync.Mutex
+	mails    []Mail
+	failures []int // the codes MAIL answers the next mails with
+	received chan struct{}
+}
+
+// NewSMTPReceiver starts an SMTP receiver, Close stops it
+func NewSMTPReceiver() (*SMTPReceiver, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	r := SMTPReceiver{listener: l, received: make(chan struct{}, 1)}
+	go r.accept()
+	return &r, nil
+}
+
+// Addr is where the receiver listens, like 127.0.0.1:40123
+func (r *SMTPReceiver) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// FailNext answers MAIL of the next mails with codes, one each, like 451 for a failure that may go away or 550
+func (r *SMTPReceiver) FailNext(codes ...int) {
+	r.mutex.Lock()
+	r.failures = append(r.failures, codes...)
+	r.mutex.Unlock()
+}
+
+// Mails returns the mails so far
+func (r *SMTPReceiver) Mails() []Mail {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Mail(nil), r.mails...)
+}
+
+// Wait returns the mails once there are at least n, or fails after timeout
+func (r *SMTPReceiver) Wait(n int, timeout time.Duration) ([]Mail, error) {
+	deadline := time.After(timeout)
+	for {
+		if mails := r.Mails(); len(mails) >= n {
+			return mails, nil
+		}
+
+		select {
+		case <-r.received:
+		case <-deadline:
+			return r.Mails(), fmt.Errorf("expected %d mails, got %d", n, len(r.Mails()))
+		}
+	}
+}
+
+// Close stops the receiver once the sessions going on are over
+func (r *SMTPReceiver) Close() error {
+	err := r.listener.Close()
+	r.conns.Wait()
+	return err
+}
+
+func (r *SMTPReceiver) accept() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		r.conns.Add(1)
+		go func() {
+			defer r.conns.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Minute))
+			r.session(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (r *SMTPReceiver) session(c *textproto.Conn) {
+	c.PrintfLine("220 localhost mock SMTP")
+
+	m := Mail{}
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			c.PrintfLine("250-localhost")
+			c.PrintfLine("250-AUTH PLAIN")
+			c.PrintfLine("250 8BITMIME")
+		case "HELO":
+			c.PrintfLine("250 localhost")
+		case "AUTH":
+			m.Username = plainUsername(arg)
+			c.PrintfLine("235 2.7.0 authenticated")
+		case "MAIL":
+			if code := r.nextFailure(); code != 0 {
+				c.PrintfLine("%d mock failure", code)
+				continue
+			}
+			m.From = address(arg)
+			m.To = nil
+			c.PrintfLine("250 2.1.0 ok")
+		case "RCPT":
+			m.To = append(m.To, address(arg))
+			c.PrintfLine("250 2.1.5 ok")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.Data = string(data)
+			if msg, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+				m.Subject = msg.Header.Get("Subject")
+				body, _ := ioutil.ReadAll(msg.Body)
+				m.Body = string(body)
+			}
+			r.keep(m)
+			m = Mail{Username: m.Username}
+			c.PrintfLine("250 2.0.0 queued")
+		case "RSET":
+			m = Mail{Username: m.Username}
+			c.PrintfLine("250 2.0.0 ok")
+		case "NOOP":
+			c.PrintfLine("250 2.0.0 ok")
+		case "QUIT":
+			c.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			c.PrintfLine("502 5.5.2 not implemented")
+		}
+	}
+}
+
+func (r *SMTPReceiver) nextFailure() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.failures) == 0 {
+		return 0
+	}
+	code := r.failures[0]
+	r.failures = r.failures[1:]
+	return code
+}
+
+func (r *SMTPReceiver) keep(m Mail) {
+	r.mutex.Lock()
+	r.mails = append(r.mails, m)
+	r.mutex.Unlock()
+
+	notify(r.received)
+}
+
+// address returns the address of "FROM:<jane@example.com> BODY=8BITMIME"
+func address(arg string) string {
+	start, end := strings.IndexByte(arg, '<'), strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		return arg
+	}
+
+	return arg[start+1 : end]
+}
+
+// plainUsername returns the username of "PLAIN <base64 of \0username\0password>"
+func plainUsername(arg string) string {
+	fields := strings.Fields(arg)
+	if len(fields) != 2 {
+		return ""
+	}
+
+	b, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(string(b), "\x00")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	return parts[1]
+}
@@ -0,0 +1,63 @@
+/*
+notifier sends the alarm transitions the coordinators publish on to the sinks of notifier.sinks, so the operators
+hear about an alarm without a browser open: a webhook, a mail or a command of their own.
+
+Every sink is set up like config.NotifierSink says and gets the transitions it wants in order, one at a time,
+a slow or failing sink doesn't hold up the others.
+*/
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+)
+
+// Notification is what a sink gets of a transition, the templates of the sinks are executed on it
+type Notification struct {
+	Alarm        string    `json:"alarm"`            // see dto.AlarmTransition.Alarm
+	Sensor       string    `json:"sensor,omitempty"` // the one whose reading did it
+	Rule         string    `json:"rule,omitempty"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Value        float64   `json:"value"`
+	MinSafeValue float64   `json:"minSafeValue"`
+	MaxSafeValue float64   `json:"maxSafeValue"`
+	Timestamp    time.Time `json:"timestamp"`
+	Resolved     bool      `json:"resolved"`             // back to Normal
+	Suppressed   int       `json:"suppressed,omitempty"` // left out by the rate limit of the sink since the one before
+}
+
+func newNotification(t dto.AlarmTransition) Notification {
+	return Notification{
+		Alarm:        t.Alarm(),
+		Sensor:       t.Sensor,
+		Rule:         t.Rule,
+		From:         string(t.From),
+		To:           string(t.To),
+		Value:        t.Value,
+		MinSafeValue: t.MinSafeValue,
+		MaxSafeValue: t.MaxSafeValue,
+		Timestamp:    t.Timestamp,
+		Resolved:     t.To == dto.StateNormal,
+	}
+}
+
+// Summary is a line about the notification, like "boiler_pressure_out: Normal -> Alarm at 4.31 (safe 1.5 to 4.25)"
+func (n Notification) Summary() string {
+	s := fmt.Sprintf("%s: %s -> %s", n.Alarm, n.From, n.To)
+
+	switch {
+	case n.Rule != "" && n.Sensor != "":
+		s += fmt.Sprintf(" by %s at %g", n.Sensor, n.Value)
+	case n.Rule == "":
+		s += fmt.Sprintf(" at %g (safe %g to %g)", n.Value, n.MinSafeValue, n.MaxSafeValue)
+	}
+
+	if n.Suppressed > 0 {
+		s += fmt.Sprintf(", %d more left out", n.Suppressed)
+	}
+
+	return s
+}
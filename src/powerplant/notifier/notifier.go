@@ -0,0 +1,143 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+	"github.com/streadway/amqp"
+)
+
+/*
+Notifier consumes NotificationsQueue, which is bound to AlarmsExchange, and hands every transition to the sinks:
+1, a transition is acked once the sinks that want it have it queued, one that doesn't decode is dead-lettered
+2, the notifiers share the queue, so with more than one running every transition is sent by one of them
+3, Close gives the sinks until its ctx is done to send what they have queued
+
+!!! a notification still queued for a sink when the notifier stops is lost, the queue only keeps the transitions
+while no notifier is running
+*/
+type Notifier struct {
+	dispatchers []*dispatcher
+	workers     sync.WaitGroup
+
+	ctx    context.Context // of the sends, cancelled once the ctx given to Close is done
+	cancel context.CancelFunc
+}
+
+// New sets up the sinks and starts sending, it fails on a sink that can't be set up
+func New(sinks []config.NotifierSink) (*Notifier, error) {
+	nt := Notifier{}
+	nt.ctx, nt.cancel = context.WithCancel(context.Background())
+
+	for _, s := range sinks {
+		// checked with the configuration already, but a rateLimit without a ratePeriod mustn't get to the rateLimiter
+		if err := s.Validate(); err != nil {
+			nt.cancel()
+			return nil, fmt.Errorf("sink %s: %s", s.Name, err)
+		}
+
+		sink, err := NewSink(s)
+		if err != nil {
+			nt.cancel()
+			return nil, fmt.Errorf("sink %s: %s", s.Name, err)
+		}
+		nt.dispatchers = append(nt.dispatchers, newDispatcher(s, sink))
+	}
+
+	for _, d := range nt.dispatchers {
+		nt.workers.Add(1)
+		go func(d *dispatcher) {
+			defer nt.workers.Done()
+			d.run(nt.ctx)
+		}(d)
+	}
+
+	return &nt, nil
+}
+
+// Run consumes the transitions until ctx is done, retryDelay is the one queueutils.DeclareWorkQueue
+// declares the queue with, there are no retries but the queue dead-letters what doesn't decode
+func (nt *Notifier) Run(ctx context.Context, broker queueutils.Broker, retryDelay time.Duration) error {
+	// the coordinator declares it too, but the notifier may well be started first
+	broker.DeclareExchange(queueutils.AlarmsExchange, queueutils.TopicExchange)
+
+	queueName, err := queueutils.DeclareWorkQueue(broker, queueutils.NotificationsQueue, retryDelay)
+	if err != nil {
+		return err
+	}
+	broker.BindQueue(
+		queueName,                 //queue string,
+		"#",                       //key string,
+		queueutils.AlarmsExchange) //exchange string)
+
+	msgs, err := queueutils.ConsumeContext(ctx, broker,
+		queueName, //queue string,
+		"",        //consumer string,
+		false,     //autoAck bool,
+		false)     //exclusive bool)
+	if err != nil {
+		return err
+	}
+
+	for msg := range msgs {
+		nt.handle(msg)
+	}
+
+	// the broker reconnects on its own, so the channel only closes when asked to stop
+	return nil
+}
+
+func (nt *Notifier) handle(msg amqp.Delivery) {
+	t, err := dto.DecodeAlarmTransition(queueutils.FromDelivery(msg))
+	if err != nil {
+		log.Printf("Failed to decode alarm message %v, dead-lettering it. Error: %s", msg.MessageId, err.Error())
+		msg.Reject(false)
+		return
+	}
+
+	nt.Notify(t)
+
+	if err := msg.Ack(false); err != nil {
+		log.Printf("Failed to ack the alarm of %v. Error: %s", t.Alarm(), err.Error())
+	}
+}
+
+// Notify hands a transition to the sinks that want it
+func (nt *Notifier) Notify(t dto.AlarmTransition) {
+	n := newNotification(t)
+	now := time.Now()
+
+	for _, d := range nt.dispatchers {
+		d.offer(n, now)
+	}
+}
+
+// Close waits for the sinks to send what they have queued until ctx is done,
+// Run has to have returned
+func (nt *Notifier) Close(ctx context.Context) error {
+	for _, d := range nt.dispatchers {
+		close(d.queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		nt.workers.Wait()
+		close(done)
+	}()
+
+	defer nt.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		nt.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,208 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/notifier/mockreceiver"
+	"github.com/golang-distributed-application/src/powerplant/queueutils"
+)
+
+// the sinks are tried again sooner than they would be for real
+var testBackoff = queueutils.Backoff{Initial: 20 * time.Millisecond, Max: 40 * time.Millisecond}
+
+var raisedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func transition(alarm string, from, to dto.AlarmState, at time.Time) dto.AlarmTransition {
+	return dto.AlarmTransition{Sensor: alarm, From: from, To: to, Value: 4.3, MinSafeValue: 1.5, MaxSafeValue: 4.25, Timestamp: at}
+}
+
+// webhookDispatcher returns a dispatcher of a webhook sink posting to r, offer it notifications and run it
+func webhookDispatcher(t *testing.T, r *mockreceiver.HTTPReceiver, settings config.NotifierSink) *dispatcher {
+	settings.Name, settings.Type = "hook", config.WebhookSink
+	settings.Webhook = config.Webhook{URL: r.URL(), Body: `{{.Alarm}} {{.To}} {{.Suppressed}}`}
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := NewSink(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newDispatcher(settings, sink)
+}
+
+// bodies runs d until its queue is empty and returns the bodies r got
+func bodies(d *dispatcher, r *mockreceiver.HTTPReceiver) []string {
+	close(d.queue)
+	d.run(context.Background())
+
+	got := []string{}
+	for _, req := range r.Requests() {
+		got = append(got, req.Body)
+	}
+	return got
+}
+
+func TestDedup(t *testing.T) {
+	r := mockreceiver.NewHTTPReceiver()
+	defer r.Close()
+	d := webhookDispatcher(t, r, config.NotifierSink{Dedup: time.Minute})
+
+	now := time.Now()
+	for _, tr := range []dto.AlarmTransition{
+		transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt),
+		transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt),                     // delivered twice
+		transition("boiler", dto.StateAlarm, dto.StateNormal, raisedAt.Add(time.Second)),    // another state
+		transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt.Add(30*time.Second)), // within dedup
+		transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt.Add(2*time.Minute)),  // after it
+		transition("turbine", dto.StateNormal, dto.StateAlarm, raisedAt),                    // another alarm
+	} {
+		d.offer(newNotification(tr), now)
+	}
+
+	got := bodies(d, r)
+	want := []string{"boiler Alarm 0", "boiler Normal 0", "boiler Alarm 0", "turbine Alarm 0"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	r := mockreceiver.NewHTTPReceiver()
+	defer r.Close()
+	d := webhookDispatcher(t, r, config.NotifierSink{RateLimit: 2, RatePeriod: time.Minute})
+
+	now := time.Now()
+	for i, alarm := range []string{"a", "b", "c", "d"} {
+		d.offer(newNotification(transition(alarm, dto.StateNormal, dto.StateAlarm, raisedAt.Add(time.Duration(i)*time.Second))), now)
+	}
+	// half the period gives back one of the two tokens
+	d.offer(newNotification(transition("e", dto.StateNormal, dto.StateAlarm, raisedAt)), now.Add(30*time.Second))
+	d.offer(newNotification(transition("f", dto.StateNormal, dto.StateAlarm, raisedAt)), now.Add(30*time.Second))
+
+	got := bodies(d, r)
+	want := []string{"a Alarm 0", "b Alarm 0", "e Alarm 2"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSeverityAndAlarms(t *testing.T) {
+	r := mockreceiver.NewHTTPReceiver()
+	defer r.Close()
+	d := webhookDispatcher(t, r, config.NotifierSink{Alarms: []string{"boiler_*", "rule.*"}, MinSeverity: dto.StateAlarm})
+
+	now := time.Now()
+	rule := transition("turbine_temp", dto.StateNormal, dto.StateAlarm, raisedAt)
+	rule.Rule = "overheat"
+	for _, tr := range []dto.AlarmTransition{
+		transition("turbine_temp", dto.StateNormal, dto.StateAlarm, raisedAt),      // not matched
+		transition("boiler_pressure", dto.StateNormal, dto.StateWarning, raisedAt), // not severe enough
+		transition("boiler_pressure", dto.StateWarning, dto.StateAlarm, raisedAt),
+		transition("boiler_pressure", dto.StateAlarm, dto.StateNormal, raisedAt.Add(time.Second)), // the resolve
+		rule,
+	} {
+		d.offer(newNotification(tr), now)
+	}
+
+	got := bodies(d, r)
+	want := []string{"boiler_pressure Alarm 0", "boiler_pressure Normal 0", "rule.overheat Alarm 0"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(b queueutils.Backoff) { sinkBackoff = b }(sinkBackoff)
+	sinkBackoff = testBackoff
+
+	r := mockreceiver.NewHTTPReceiver()
+	defer r.Close()
+	d := webhookDispatcher(t, r, config.NotifierSink{MaxAttempts: 3})
+
+	// a 5xx is tried again after the backoff, a 400 isn't
+	r.FailNext(500, 503)
+	d.offer(newNotification(transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt)), time.Now())
+	r.FailNext(400)
+	d.offer(newNotification(transition("boiler", dto.StateAlarm, dto.StateNormal, raisedAt.Add(time.Second))), time.Now())
+
+	started := time.Now()
+	got := bodies(d, r)
+	want := []string{"boiler Alarm 0", "boiler Alarm 0", "boiler Alarm 0", "boiler Normal 0"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	// the jitter of the backoff takes up to an eighth off the 20ms and the 40ms
+	if waited := time.Since(started); waited < (testBackoff.Initial+2*testBackoff.Initial)*7/8 {
+		t.Fatalf("expected the attempts to back off, all of them took %v", waited)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	defer func(b queueutils.Backoff) { sinkBackoff = b }(sinkBackoff)
+	sinkBackoff = testBackoff
+
+	r := mockreceiver.NewHTTPReceiver()
+	defer r.Close()
+	d := webhookDispatcher(t, r, config.NotifierSink{MaxAttempts: 2})
+
+	r.FailNext(503, 503, 503)
+	d.offer(newNotification(transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt)), time.Now())
+
+	if got := bodies(d, r); len(got) != 2 {
+		t.Fatalf("expected 2 attempts, got %v", got)
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	defer func(b queueutils.Backoff) { sinkBackoff = b }(sinkBackoff)
+	sinkBackoff = testBackoff
+
+	r, err := mockreceiver.NewSMTPReceiver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	nt, err := New([]config.NotifierSink{{Name: "mail", Type: config.SMTPSink, SMTP: config.SMTP{
+		Addr: r.Addr(), Username: "plant", Password: "secret", From: "plant@example.com", To: []string{"oncall@example.com"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 451 is tried again, 550 isn't
+	r.FailNext(451)
+	nt.Notify(transition("boiler", dto.StateNormal, dto.StateAlarm, raisedAt))
+	if _, err := r.Wait(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	r.FailNext(550)
+	nt.Notify(transition("boiler", dto.StateAlarm, dto.StateNormal, raisedAt.Add(time.Second)))
+
+	if err := nt.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mails := r.Mails()
+	if len(mails) != 1 {
+		t.Fatalf("expected 1 mail, got %+v", mails)
+	}
+	m := mails[0]
+	if m.Username != "plant" || m.From != "plant@example.com" || len(m.To) != 1 || m.To[0] != "oncall@example.com" ||
+		m.Subject != "[Alarm] boiler" || !strings.Contains(m.Body, "boiler: Normal -> Alarm") {
+		t.Fatalf("unexpected mail %+v", m)
+	}
+}
+
+func TestRatePeriodRequired(t *testing.T) {
+	_, err := New([]config.NotifierSink{{Name: "hook", Type: config.WebhookSink, RateLimit: 5,
+		Webhook: config.Webhook{URL: "http://127.0.0.1/hook"}}})
+	if err == nil || !strings.Contains(err.Error(), "ratePeriod") {
+		t.Fatalf("expected a sink with a rateLimit but no ratePeriod to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+)
+
+// Sink sends a notification somewhere, Send returns once it's there or it has failed
+type Sink interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// NewSink sets up the sink of s, it fails on a template that doesn't parse
+func NewSink(s config.NotifierSink) (Sink, error) {
+	switch s.Type {
+	case config.WebhookSink:
+		return newWebhookSink(s.Webhook)
+	case config.SMTPSink:
+		return newSMTPSink(s.SMTP)
+	case config.ExecSink:
+		return newExecSink(s.Exec)
+	}
+
+	return nil, fmt.Errorf("unknown sink type '%s'", s.Type)
+}
+
+// permanentError is a failure that won't go away by trying again, like a webhook that answers 400
+type permanentError struct {
+	error
+}
+
+// permanent marks err as one that isn't worth another attempt
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// templateFuncs are there for the templates of the sinks besides the methods of Notification,
+// json quotes a value for a JSON body: {"text": {{json .Summary}}}
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b := strings.Builder{}
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false) // the conditions of the rules are full of < and >
+		err := enc.Encode(v)
+		return strings.TrimSuffix(b.String(), "\n"), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// parseTemplate parses text, or fallback if it's empty
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return t, nil
+}
+
+// execute executes t on n, failing for good since it would fail the same way again
+func execute(t *template.Template, n Notification) (string, error) {
+	b := strings.Builder{}
+	if err := t.Execute(&b, n); err != nil {
+		return "", permanent(err)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,133 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+)
+
+// the mail a notification makes unless the settings say otherwise
+const (
+	defaultSubject = "[{{.To}}] {{.Alarm}}{{if .Resolved}} resolved{{end}}"
+	defaultBody    = `{{.Summary}}
+
+Alarm:     {{.Alarm}}
+From:      {{.From}}
+To:        {{.To}}
+Timestamp: {{.Timestamp.UTC.Format "2006-01-02T15:04:05Z07:00"}}
+`
+)
+
+// smtpSink mails the notifications, a 5xx answer of the server fails for good
+type smtpSink struct {
+	settings config.SMTP
+	subject  *template.Template
+	body     *template.Template
+}
+
+func newSMTPSink(settings config.SMTP) (*smtpSink, error) {
+	s := smtpSink{settings: settings}
+
+	var err error
+	if s.subject, err = parseTemplate("smtp.subject", settings.Subject, defaultSubject); err != nil {
+		return nil, err
+	}
+	if s.body, err = parseTemplate("smtp.body", settings.Body, defaultBody); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *smtpSink) Send(ctx context.Context, n Notification) error {
+	subject, err := execute(s.subject, n)
+	if err != nil {
+		return err
+	}
+	body, err := execute(s.body, n)
+	if err != nil {
+		return err
+	}
+
+	msg := strings.Builder{}
+	fmt.Fprintf(&msg, "From: %s\r\n", s.settings.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.settings.To, ", "))
+	// !!! a header ends at the line break, a template shouldn't be able to add headers of its own
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	err = s.send(ctx, []byte(msg.String()))
+
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
+
+// send is smtp.SendMail on a connection that gives up once ctx is done
+func (s *smtpSink) send(ctx context.Context, msg []byte) error {
+	host, _, _ := net.SplitHostPort(s.settings.Addr)
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", s.settings.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.settings.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.settings.Username, s.settings.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.settings.From); err != nil {
+		return err
+	}
+	for _, to := range s.settings.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
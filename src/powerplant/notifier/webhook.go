@@ -0,0 +1,80 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/golang-distributed-application/src/powerplant/config"
+)
+
+// webhookSink sends the notifications to an HTTP endpoint, a 2xx answer is a success,
+// a 429 or a 5xx one is tried again and any other fails for good
+type webhookSink struct {
+	settings config.Webhook
+	body     *template.Template // nil for the notification as JSON
+	client   *http.Client
+}
+
+func newWebhookSink(settings config.Webhook) (*webhookSink, error) {
+	s := webhookSink{settings: settings, client: &http.Client{}}
+	if s.settings.Method == "" {
+		s.settings.Method = http.MethodPost
+	}
+
+	if settings.Body != "" {
+		var err error
+		if s.body, err = parseTemplate("webhook.body", settings.Body, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+func (s *webhookSink) Send(ctx context.Context, n Notification) error {
+	var body string
+	if s.body == nil {
+		b, err := json.Marshal(n)
+		if err != nil {
+			return permanent(err)
+		}
+		body = string(b)
+	} else {
+		var err error
+		if body, err = execute(s.body, n); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, s.settings.Method, s.settings.URL, strings.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.settings.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// !!! read to the end, so the connection can be used again
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%s answered %s", s.settings.URL, resp.Status)
+	}
+
+	return permanent(fmt.Errorf("%s answered %s", s.settings.URL, resp.Status))
+}
@@ -0,0 +1,637 @@
+package queueutils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNotConnected is returned by the RabbitMQ backend while it's reconnecting
+var ErrNotConnected = errors.New("not connected to the message broker")
+
+/*
+amqpBroker is the RabbitMQ backend, it owns one connection with one channel.
+
+It remembers the exchanges, queues, bindings and consumers declared through it, so once the
+channel is lost (broker restart, network failure, or a channel error) it dials again with
+exponential backoff, declares them all over again and keeps feeding the same delivery channels
+to its consumers. Callers only see the delivery channel close when they cancel the consumer
+or close the broker.
+*/
+type amqpBroker struct {
+	url     string
+	backoff Backoff
+
+	mutex     sync.Mutex
+	conn      *amqp.Connection
+	ch        *amqp.Channel // nil while reconnecting
+	closed    bool
+	exchanges []amqpExchange
+	queues    []*amqpQueue
+	bindings  []amqpBinding
+	consumers map[string]*amqpConsumer
+	tags      int // counter for the consumer tags made up when the caller doesn't give one
+
+	// confirm mode is switched on by the first PublishConfirmed and stays on across reconnections,
+	// from then on every message published on the channel gets the next delivery tag
+	confirming bool
+	publishTag uint64
+	confirms   *amqpConfirms // the confirmations of the current channel
+}
+
+// amqpConfirms keeps track of the confirmed publishes waiting for an answer from one channel,
+// it has its own mutex since the confirmations must be read while the broker's mutex is held for an RPC
+type amqpConfirms struct {
+	mutex    sync.Mutex
+	waiting  map[uint64]chan error
+	returned map[uint64]bool
+}
+
+// publishTagHeader carries the delivery tag of a confirmed publish, so a returned message can be matched up with its ack
+const publishTagHeader = "x-publish-tag"
+
+type amqpExchange struct {
+	name string
+	kind string
+}
+
+type amqpQueue struct {
+	name        string // the name handed out by DeclareQueue, callers keep using it after reconnecting
+	current     string // the name on the current connection, differs from name for server-named queues
+	serverNamed bool
+	autoDelete  bool
+	args        amqp.Table
+}
+
+type amqpBinding struct {
+	queue    string
+	key      string
+	exchange string
+}
+
+type amqpConsumer struct {
+	tag        string
+	queue      string
+	autoAck    bool
+	exclusive  bool
+	deliveries chan amqp.Delivery
+	done       chan struct{} // closed on cancel, so a forwarder blocked on sending gives up
+	cancelled  bool
+	forwarders int // the forwarder that exits last closes deliveries once the consumer is cancelled
+}
+
+func dialAMQP(url string) (*amqpBroker, error) {
+	// a malformed url is never going to work, so there's no point retrying it
+	if _, err := amqp.ParseURI(url); err != nil {
+		return nil, err
+	}
+
+	b := amqpBroker{
+		url:       url,
+		backoff:   ReconnectBackoff,
+		consumers: make(map[string]*amqpConsumer),
+	}
+
+	// !!! keep trying, so the processes of the plant can be started before the broker
+	for attempt := 0; ; attempt++ {
+		b.mutex.Lock()
+		err := b.connect()
+		b.mutex.Unlock()
+
+		if err == nil {
+			return &b, nil
+		}
+
+		delay := b.backoff.Delay(attempt)
+		log.Printf("Failed to connect to message broker, retrying in %v: %s\n", delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// connect dials the broker and restores everything declared so far, the caller holds the mutex
+func (b *amqpBroker) connect() error {
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	b.conn, b.ch = conn, ch
+	b.publishTag = 0
+	if err := b.restore(); err != nil {
+		b.conn, b.ch = nil, nil
+		conn.Close()
+		return err
+	}
+
+	// a lost connection closes the channel too, so watching the channel covers both
+	go b.watch(ch.NotifyClose(make(chan *amqp.Error, 1)))
+	return nil
+}
+
+func (b *amqpBroker) restore() error {
+	if b.confirming {
+		if err := b.enableConfirms(); err != nil {
+			return err
+		}
+	}
+
+	for _, ex := range b.exchanges {
+		if err := b.declareExchange(ex.name, ex.kind); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range b.queues {
+		name := q.name
+		if q.serverNamed {
+			name = ""
+		}
+
+		current, err := b.declareQueue(name, q.autoDelete, q.args)
+		if err != nil {
+			return err
+		}
+		q.current = current
+	}
+
+	for _, binding := range b.bindings {
+		if err := b.bindQueue(b.currentName(binding.queue), binding.key, binding.exchange); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range b.consumers {
+		if err := b.consume(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watch waits for the channel to close and reconnects unless it was closed on purpose
+func (b *amqpBroker) watch(closed chan *amqp.Error) {
+	err := <-closed
+
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return
+	}
+
+	log.Printf("Lost connection to message broker, reconnecting: %v\n", err)
+	// a channel error leaves the connection itself open
+	b.conn.Close()
+	b.conn, b.ch = nil, nil
+	b.mutex.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		time.Sleep(b.backoff.Delay(attempt))
+
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			return
+		}
+		err := b.connect()
+		b.mutex.Unlock()
+
+		if err == nil {
+			log.Println("Reconnected to message broker")
+			return
+		}
+
+		log.Printf("Failed to reconnect to message broker: %s\n", err)
+	}
+}
+
+func (b *amqpBroker) channel() (*amqp.Channel, error) {
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	if b.ch == nil {
+		return nil, ErrNotConnected
+	}
+
+	return b.ch, nil
+}
+
+func (b *amqpBroker) currentName(queue string) string {
+	for _, q := range b.queues {
+		if q.name == queue {
+			return q.current
+		}
+	}
+
+	return queue
+}
+
+func (b *amqpBroker) DeclareQueue(name string, autoDelete bool, args amqp.Table) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.channel(); err != nil {
+		return "", err
+	}
+
+	declared, err := b.declareQueue(name, autoDelete, args)
+	if err != nil {
+		return "", err
+	}
+
+	for _, q := range b.queues {
+		if q.name == declared {
+			return declared, nil
+		}
+	}
+
+	b.queues = append(b.queues, &amqpQueue{
+		name:        declared,
+		current:     declared,
+		serverNamed: name == "",
+		autoDelete:  autoDelete,
+		args:        args,
+	})
+
+	return declared, nil
+}
+
+func (b *amqpBroker) declareQueue(name string, autoDelete bool, args amqp.Table) (string, error) {
+	q, err := b.ch.QueueDeclare(
+		name,       //name string,
+		false,      //durable bool,
+		autoDelete, //autoDelete bool,
+		false,      //exclusive bool,
+		false,      //noWait bool,
+		args)       //args amqp.Table)
+
+	return q.Name, err
+}
+
+func (b *amqpBroker) DeclareExchange(name, kind string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.channel(); err != nil {
+		return err
+	}
+
+	if err := b.declareExchange(name, kind); err != nil {
+		return err
+	}
+
+	exchange := amqpExchange{name: name, kind: kind}
+	for _, ex := range b.exchanges {
+		if ex == exchange {
+			return nil
+		}
+	}
+
+	b.exchanges = append(b.exchanges, exchange)
+	return nil
+}
+
+func (b *amqpBroker) declareExchange(name, kind string) error {
+	return b.ch.ExchangeDeclare(
+		name,  //name string,
+		kind,  //kind string,
+		false, //durable bool,
+		false, //autoDelete bool,
+		false, //internal bool,
+		false, //noWait bool,
+		nil)   //args amqp.Table)
+}
+
+func (b *amqpBroker) BindQueue(queue, key, exchange string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.channel(); err != nil {
+		return err
+	}
+
+	if err := b.bindQueue(b.currentName(queue), key, exchange); err != nil {
+		return err
+	}
+
+	binding := amqpBinding{queue: queue, key: key, exchange: exchange}
+	for _, existing := range b.bindings {
+		if existing == binding {
+			return nil
+		}
+	}
+
+	b.bindings = append(b.bindings, binding)
+	return nil
+}
+
+func (b *amqpBroker) bindQueue(queue, key, exchange string) error {
+	return b.ch.QueueBind(
+		queue,    //name string,
+		key,      //key string,
+		exchange, //exchange string,
+		false,    //noWait bool,
+		nil)      //args amqp.Table)
+}
+
+func (b *amqpBroker) Publish(exchange, key string, msg amqp.Publishing) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.Publish(
+		exchange, //exchange string,
+		key,      //key string,
+		false,    //mandatory bool,
+		false,    //immediate bool,
+		msg)      //msg amqp.Publishing)
+
+	if err == nil && b.confirming {
+		b.publishTag++
+	}
+
+	return err
+}
+
+func (b *amqpBroker) PublishConfirmed(exchange, key string, msg amqp.Publishing) (<-chan error, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch, err := b.channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if !b.confirming {
+		if err := b.enableConfirms(); err != nil {
+			return nil, err
+		}
+		b.confirming = true
+	}
+
+	tag := b.publishTag + 1
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[publishTagHeader] = int64(tag)
+	msg.Headers = headers
+
+	// !!! register before publishing, the ack may well arrive before Publish returns
+	done := make(chan error, 1)
+	c := b.confirms
+	c.mutex.Lock()
+	c.waiting[tag] = done
+	c.mutex.Unlock()
+
+	err = ch.Publish(
+		exchange, //exchange string,
+		key,      //key string,
+		true,     //mandatory bool,
+		false,    //immediate bool,
+		msg)      //msg amqp.Publishing)
+
+	if err != nil {
+		c.mutex.Lock()
+		delete(c.waiting, tag)
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	b.publishTag = tag
+	return done, nil
+}
+
+// enableConfirms puts the current channel in confirm mode
+func (b *amqpBroker) enableConfirms() error {
+	if err := b.ch.Confirm(false); err != nil {
+		return err
+	}
+
+	b.confirms = &amqpConfirms{
+		waiting:  make(map[uint64]chan error),
+		returned: make(map[uint64]bool),
+	}
+
+	returns := b.ch.NotifyReturn(make(chan amqp.Return, 16))
+	acks := b.ch.NotifyPublish(make(chan amqp.Confirmation, 16))
+	go b.confirms.run(acks, returns)
+
+	return nil
+}
+
+// run answers the confirmed publishes until the channel closes
+func (c *amqpConfirms) run(acks <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for ack := range acks {
+		// an unroutable message is returned before it's acked, and both are dispatched by the
+		// same goroutine, so any return belonging to this ack is already waiting in returns
+	drain:
+		for {
+			select {
+			case ret := <-returns:
+				if tag, ok := ret.Headers[publishTagHeader].(int64); ok {
+					c.mutex.Lock()
+					c.returned[uint64(tag)] = true
+					c.mutex.Unlock()
+				}
+			default:
+				break drain
+			}
+		}
+
+		c.mutex.Lock()
+		done := c.waiting[ack.DeliveryTag]
+		returned := c.returned[ack.DeliveryTag]
+		delete(c.waiting, ack.DeliveryTag)
+		delete(c.returned, ack.DeliveryTag)
+		c.mutex.Unlock()
+
+		if done == nil {
+			// a plain Publish on a channel in confirm mode
+			continue
+		}
+
+		switch {
+		case returned:
+			done <- ErrUnroutable
+		case !ack.Ack:
+			done <- ErrNacked
+		default:
+			done <- nil
+		}
+	}
+
+	// the channel is gone, nobody is ever going to confirm what's left
+	c.mutex.Lock()
+	for tag, done := range c.waiting {
+		done <- ErrNotConnected
+		delete(c.waiting, tag)
+	}
+	c.mutex.Unlock()
+}
+
+func (b *amqpBroker) Consume(queue, consumer string, autoAck, exclusive bool) (<-chan amqp.Delivery, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.channel(); err != nil {
+		return nil, err
+	}
+
+	// the tag is needed to consume again after reconnecting, so it can't be left to the broker
+	if consumer == "" {
+		b.tags++
+		consumer = fmt.Sprintf("ctag-%s-%d-%d", filepath.Base(os.Args[0]), os.Getpid(), b.tags)
+	}
+
+	if b.consumers[consumer] != nil {
+		return nil, fmt.Errorf("consumer tag '%s' is already in use", consumer)
+	}
+
+	c := amqpConsumer{
+		tag:        consumer,
+		queue:      queue,
+		autoAck:    autoAck,
+		exclusive:  exclusive,
+		deliveries: make(chan amqp.Delivery),
+		done:       make(chan struct{}),
+	}
+
+	if err := b.consume(&c); err != nil {
+		return nil, err
+	}
+
+	b.consumers[consumer] = &c
+	return c.deliveries, nil
+}
+
+func (b *amqpBroker) consume(c *amqpConsumer) error {
+	msgs, err := b.ch.Consume(
+		b.currentName(c.queue), //queue string,
+		c.tag,       //consumer string,
+		c.autoAck,   //autoAck bool,
+		c.exclusive, //exclusive bool,
+		false,       //noLocal bool,
+		false,       //noWait bool,
+		nil)         //args amqp.Table)
+
+	if err != nil {
+		return err
+	}
+
+	c.forwarders++
+	go b.forward(c, msgs)
+	return nil
+}
+
+// forward passes the deliveries of the current channel on to the consumer
+func (b *amqpBroker) forward(c *amqpConsumer, msgs <-chan amqp.Delivery) {
+	for msg := range msgs {
+		select {
+		case c.deliveries <- msg:
+		case <-c.done:
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// msgs closes on cancel and on a lost connection alike, only the first one ends the consumer for good
+	c.forwarders--
+	if c.cancelled && c.forwarders == 0 {
+		close(c.deliveries)
+	}
+}
+
+func (b *amqpBroker) Cancel(consumer string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c := b.consumers[consumer]
+	if c == nil {
+		return fmt.Errorf("no consumer with tag '%s'", consumer)
+	}
+
+	b.cancel(c)
+
+	if b.ch != nil {
+		return b.ch.Cancel(consumer, false)
+	}
+
+	return nil
+}
+
+func (b *amqpBroker) cancel(c *amqpConsumer) {
+	delete(b.consumers, c.tag)
+
+	c.cancelled = true
+	close(c.done)
+	if c.forwarders == 0 {
+		close(c.deliveries)
+	}
+}
+
+func (b *amqpBroker) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+	for _, c := range b.consumers {
+		b.cancel(c)
+	}
+
+	if b.conn == nil {
+		return nil
+	}
+
+	b.ch.Close()
+	return b.conn.Close()
+}
+
+func (b *amqpBroker) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch, err := b.channel()
+	if err != nil {
+		return amqp.Delivery{}, false, err
+	}
+
+	return ch.Get(b.currentName(queue), autoAck)
+}
+
+func (b *amqpBroker) Purge(queue string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch, err := b.channel()
+	if err != nil {
+		return 0, err
+	}
+
+	return ch.QueuePurge(
+		b.currentName(queue), //name string,
+		false)                //noWait bool)
+}
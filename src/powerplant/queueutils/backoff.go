@@ -0,0 +1,31 @@
+package queueutils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff is an exponential backoff, the delay doubles on every attempt until it reaches Max
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// ReconnectBackoff is used between attempts to (re)connect to RabbitMQ
+var ReconnectBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second}
+
+// Delay returns how long to wait before the given attempt (starting at 0),
+// a bit of jitter keeps a whole plant of processes from reconnecting in lockstep after a broker restart
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 0; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay)/4 + 1))
+	return delay - delay/8 + jitter
+}
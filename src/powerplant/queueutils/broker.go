@@ -0,0 +1,70 @@
+package queueutils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// exchange kinds understood by every broker backend
+const (
+	FanoutExchange = "fanout" // every bound queue gets a copy, routing key is ignored
+	DirectExchange = "direct" // queues bound with exactly the routing key
+	TopicExchange  = "topic"  // queues bound with a pattern, "*" matches one word and "#" zero or more
+)
+
+// DefaultFanoutExchange is pre-declared by every broker, sensors announce themselves on it
+const DefaultFanoutExchange = "amq.fanout"
+
+// Publisher sends messages to an exchange,
+// the default exchange ("") routes the message straight to the queue named by key
+type Publisher interface {
+	Publish(exchange, key string, msg amqp.Publishing) error
+}
+
+// Subscriber receives the messages of a queue,
+// the returned channel is closed once the consumer is cancelled or the broker is closed
+type Subscriber interface {
+	Consume(queue, consumer string, autoAck, exclusive bool) (<-chan amqp.Delivery, error)
+	Cancel(consumer string) error
+}
+
+// Broker is what every component talks to instead of *amqp.Channel,
+// so the same code runs against RabbitMQ or the in-process MemoryServer
+type Broker interface {
+	Publisher
+	Subscriber
+
+	// DeclareQueue returns the queue's name, which is generated by the broker when name is empty
+	DeclareQueue(name string, autoDelete bool, args amqp.Table) (string, error)
+	DeclareExchange(name, kind string) error
+	BindQueue(queue, key, exchange string) error
+
+	Close() error
+}
+
+// ConfirmPublisher is implemented by brokers that can tell whether a message has safely made it into a queue
+type ConfirmPublisher interface {
+	// PublishConfirmed publishes msg as mandatory, the returned channel receives nil once the broker
+	// has taken responsibility for the message, or the reason it hasn't
+	PublishConfirmed(exchange, key string, msg amqp.Publishing) (<-chan error, error)
+}
+
+// errors a confirmed publish can end with, the message should be published again
+var (
+	ErrUnroutable = errors.New("message was returned, no queue is bound for it")
+	ErrNacked     = errors.New("message was nacked by the broker")
+)
+
+const memoryScheme = "memory://"
+
+// Dial connects to the broker at url, "amqp://" urls go to RabbitMQ and
+// "memory://<name>" urls go to the in-process server with that name
+func Dial(url string) (Broker, error) {
+	if strings.HasPrefix(url, memoryScheme) {
+		return GetMemoryServer(strings.TrimPrefix(url, memoryScheme)).Connect(), nil
+	}
+
+	return dialAMQP(url)
+}
@@ -0,0 +1,57 @@
+package queueutils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/streadway/amqp"
+)
+
+var consumerTags uint64
+
+// ConsumerTag returns a consumer tag that's unique within the process, for consumers that have to be cancelled later
+func ConsumerTag(prefix string) string {
+	n := atomic.AddUint64(&consumerTags, 1)
+	return fmt.Sprintf("%s-%s-%d-%d", prefix, filepath.Base(os.Args[0]), os.Getpid(), n)
+}
+
+// ConsumeContext is Consume for a consumer that stops with ctx, an empty consumer gets a tag from ConsumerTag.
+// Once ctx is done the consumer is cancelled, the deliveries already on their way still come through,
+// then the channel is closed, so a consumer ranging over it finishes what it has got before it stops.
+func ConsumeContext(ctx context.Context, sub Subscriber, queue, consumer string, autoAck, exclusive bool) (<-chan amqp.Delivery, error) {
+	if consumer == "" {
+		consumer = ConsumerTag("ctag")
+	}
+
+	in, err := sub.Consume(queue, consumer, autoAck, exclusive)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan amqp.Delivery)
+
+	go func() {
+		defer close(out)
+
+		done := ctx.Done()
+		for {
+			select {
+			case <-done:
+				// the channel may have been closed already by Cancel or Close of someone else
+				sub.Cancel(consumer)
+				done = nil
+
+			case d, ok := <-in:
+				if !ok {
+					return
+				}
+				out <- d
+			}
+		}
+	}()
+
+	return out, nil
+}
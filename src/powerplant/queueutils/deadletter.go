@@ -0,0 +1,189 @@
+package queueutils
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+/*
+A work queue keeps its poison messages from going around forever:
+1, the queue has a dead-letter exchange <queue>.DLX, a message rejected without requeue goes through it to <queue>.DLQ
+2, a message that has failed but may work later is published to <queue>.Retry, which has no consumers,
+its messages expire after the retry delay and are dead-lettered back to the work queue
+3, RetriesHeader counts the failed attempts, after the last one the message is rejected and ends up in the DLQ
+*/
+
+// headers a Retrier puts on the messages it sends around
+const (
+	RetriesHeader   = "x-retries"    // failed attempts so far
+	LastErrorHeader = "x-last-error" // why the last attempt failed
+)
+
+// the arguments RabbitMQ uses for dead-lettering and expiry
+const (
+	deadLetterExchangeArg   = "x-dead-letter-exchange"
+	deadLetterRoutingKeyArg = "x-dead-letter-routing-key"
+	messageTTLArg           = "x-message-ttl"
+	deathHeader             = "x-death"
+)
+
+// DeadLetterExchangeName is the exchange the rejected messages of a work queue go through
+func DeadLetterExchangeName(queue string) string { return queue + ".DLX" }
+
+// DeadLetterQueueName is where the rejected messages of a work queue end up
+func DeadLetterQueueName(queue string) string { return queue + ".DLQ" }
+
+// RetryQueueName is where the messages of a work queue wait for their next attempt
+func RetryQueueName(queue string) string { return queue + ".Retry" }
+
+// DeclareWorkQueue declares a work queue along with its dead-letter exchange, dead-letter queue and retry queue.
+// !!! RabbitMQ refuses to declare a queue again with other arguments, so every process declaring
+// the queue has to use the same retry delay, and a queue declared without them has to be deleted first
+func DeclareWorkQueue(broker Broker, name string, retryDelay time.Duration) (string, error) {
+	dlx := DeadLetterExchangeName(name)
+	if err := broker.DeclareExchange(dlx, DirectExchange); err != nil {
+		return "", err
+	}
+
+	dlq, err := broker.DeclareQueue(DeadLetterQueueName(name), false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// rejected messages keep the routing key they were published with, which is the work queue's name
+	if err := broker.BindQueue(dlq, name, dlx); err != nil {
+		return "", err
+	}
+
+	_, err = broker.DeclareQueue(RetryQueueName(name), false, amqp.Table{
+		messageTTLArg:           int32(retryDelay / time.Millisecond),
+		deadLetterExchangeArg:   "",
+		deadLetterRoutingKeyArg: name,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return broker.DeclareQueue(name, false, amqp.Table{
+		deadLetterExchangeArg: dlx,
+	})
+}
+
+// GetWorkQueue is DeclareWorkQueue for the executors, it gives up on the process if it fails
+func GetWorkQueue(name string, broker Broker, retryDelay time.Duration) string {
+	q, err := DeclareWorkQueue(broker, name, retryDelay)
+
+	failOnError(err, "Failed to declare a work queue")
+	return q
+}
+
+// Retrier sends the failed messages of a work queue on to their next attempt, or to the dead-letter queue
+type Retrier struct {
+	pub         Publisher
+	queue       string
+	maxAttempts int
+}
+
+func NewRetrier(pub Publisher, queue string, maxAttempts int) *Retrier {
+	return &Retrier{
+		pub:         pub,
+		queue:       queue,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Attempts returns how many times a message has been tried before
+func Attempts(msg amqp.Delivery) int {
+	return int(headerInt(msg.Headers, RetriesHeader))
+}
+
+// Retry settles a message that failed with reason: it's published to the retry queue and acked,
+// or rejected into the dead-letter queue once it has used up its attempts.
+// If it can't be published it's requeued straight away, so it isn't lost.
+func (r *Retrier) Retry(msg amqp.Delivery, reason error) error {
+	attempts := Attempts(msg) + 1
+	if attempts >= r.maxAttempts {
+		return msg.Reject(false)
+	}
+
+	// x-death is added anew on every expiry, there's no point carrying it around
+	retry := ClearAttempts(Republishing(msg))
+	retry.Headers[RetriesHeader] = int32(attempts)
+	retry.Headers[LastErrorHeader] = reason.Error()
+
+	if err := r.pub.Publish("", RetryQueueName(r.queue), retry); err != nil {
+		msg.Nack(false, true)
+		return err
+	}
+
+	return msg.Ack(false)
+}
+
+// Republishing turns a delivery back into a message with the same properties and body
+func Republishing(msg amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
+
+// ClearAttempts drops the record of the failed attempts from a message, so it's tried as if it were new
+func ClearAttempts(msg amqp.Publishing) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	delete(headers, RetriesHeader)
+	delete(headers, LastErrorHeader)
+	delete(headers, deathHeader)
+
+	msg.Headers = headers
+	return msg
+}
+
+// Death is an entry of the x-death header RabbitMQ puts on dead-lettered messages
+type Death struct {
+	Queue  string
+	Reason string // rejected, expired or maxlen
+	Count  int64
+}
+
+// Deaths returns where and why a message has been dead-lettered, the latest first
+func Deaths(msg amqp.Delivery) []Death {
+	entries, _ := msg.Headers[deathHeader].([]interface{})
+
+	deaths := []Death{}
+	for _, e := range entries {
+		t, ok := e.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		queue, _ := t["queue"].(string)
+		reason, _ := t["reason"].(string)
+		deaths = append(deaths, Death{Queue: queue, Reason: reason, Count: headerInt(t, "count")})
+	}
+
+	return deaths
+}
+
+// QueueInspector is implemented by brokers that can take single messages off a queue and empty it,
+// which is what tools looking into a queue need rather than a consumer
+type QueueInspector interface {
+	// Get takes the first message of the queue, ok is false if the queue is empty
+	Get(queue string, autoAck bool) (msg amqp.Delivery, ok bool, err error)
+	// Purge drops every message of the queue that isn't out with a consumer, and returns how many there were
+	Purge(queue string) (int, error)
+}
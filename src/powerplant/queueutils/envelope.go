@@ -0,0 +1,69 @@
+package queueutils
+
+import (
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/streadway/amqp"
+)
+
+// headers for the envelope fields that have no AMQP property of their own
+const (
+	SchemaVersionHeader = "schema-version"
+	SequenceHeader      = "sequence"
+)
+
+// ToPublishing maps an envelope onto the AMQP message properties,
+// the producer ID goes into AppId and the content type into ContentType, where consumers pick the codec from
+func ToPublishing(env dto.Envelope) amqp.Publishing {
+	return amqp.Publishing{
+		Headers: amqp.Table{
+			SchemaVersionHeader: int32(env.SchemaVersion),
+			SequenceHeader:      int64(env.Sequence),
+		},
+		ContentType: env.ContentType,
+		MessageId:   env.MessageID,
+		Timestamp:   env.Timestamp,
+		Type:        env.Type,
+		AppId:       env.ProducerID,
+		Body:        env.Body,
+	}
+}
+
+// FromDelivery is the reverse of ToPublishing
+func FromDelivery(msg amqp.Delivery) dto.Envelope {
+	return dto.Envelope{
+		SchemaVersion: int(headerInt(msg.Headers, SchemaVersionHeader)),
+		ContentType:   msg.ContentType,
+		Type:          msg.Type,
+		MessageID:     msg.MessageId,
+		ProducerID:    msg.AppId,
+		Sequence:      uint64(headerInt(msg.Headers, SequenceHeader)),
+		Timestamp:     msg.Timestamp,
+		Body:          msg.Body,
+	}
+}
+
+// headerInt reads an integer header whatever integer type it has been decoded to
+func headerInt(headers amqp.Table, key string) int64 {
+	switch v := headers[key].(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	}
+
+	return 0
+}
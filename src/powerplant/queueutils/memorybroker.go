@@ -0,0 +1,766 @@
+package queueutils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+/*
+MemoryServer is an in-process stand-in for RabbitMQ, so the whole powerplant pipeline
+(sensors, coordinator, datamanager and web app) can run inside one binary without a live broker.
+
+It follows the parts of the AMQP model this project relies on:
+1, the default exchange routes to the queue named by the routing key, fanout exchanges copy
+to every bound queue, direct and topic exchanges match the binding key
+2, each Connect() behaves like a connection with one channel: closing it cancels its consumers
+and requeues the messages they haven't acked yet
+3, auto-delete queues go away once their last consumer is gone, and an exclusive consumer
+keeps everybody else off its queue
+4, the x-dead-letter-exchange, x-dead-letter-routing-key and x-message-ttl queue arguments work like they
+do on RabbitMQ, see DeclareWorkQueue
+*/
+type MemoryServer struct {
+	mutex     sync.Mutex
+	exchanges map[string]*memoryExchange
+	queues    map[string]*memoryQueue
+	generated int // counter for the queue names and consumer tags made up by the server
+}
+
+type memoryExchange struct {
+	kind     string
+	bindings []memoryBinding
+}
+
+type memoryBinding struct {
+	queue string
+	key   string
+}
+
+type memoryQueue struct {
+	name        string
+	autoDelete  bool
+	args        amqp.Table
+	messages    []memoryMessage
+	consumers   []*memoryConsumer
+	hadConsumer bool       // auto-delete only kicks in after the first consumer has come and gone
+	cond        *sync.Cond // wakes up the consumers when a message arrives, shares the server's mutex
+}
+
+type memoryMessage struct {
+	exchange    string
+	key         string
+	redelivered bool
+	expires     time.Time // zero unless the queue has a message TTL
+	msg         amqp.Publishing
+}
+
+var memoryServers = struct {
+	sync.Mutex
+	servers map[string]*MemoryServer
+}{servers: make(map[string]*MemoryServer)}
+
+// GetMemoryServer returns the process-wide server with the given name, creating it on first use,
+// this is what Dial hands out for "memory://<name>" urls
+func GetMemoryServer(name string) *MemoryServer {
+	memoryServers.Lock()
+	defer memoryServers.Unlock()
+
+	s, ok := memoryServers.servers[name]
+	if !ok {
+		s = NewMemoryServer()
+		memoryServers.servers[name] = s
+	}
+
+	return s
+}
+
+// NewMemoryServer returns an empty server with the amq.* exchanges RabbitMQ pre-declares
+func NewMemoryServer() *MemoryServer {
+	s := MemoryServer{
+		exchanges: map[string]*memoryExchange{
+			DefaultFanoutExchange: {kind: FanoutExchange},
+			"amq.direct":          {kind: DirectExchange},
+			"amq.topic":           {kind: TopicExchange},
+		},
+		queues: make(map[string]*memoryQueue),
+	}
+
+	return &s
+}
+
+// Connect opens a new client on the server
+func (s *MemoryServer) Connect() Broker {
+	return &memoryBroker{
+		server:    s,
+		consumers: make(map[string]*memoryConsumer),
+		unacked:   make(map[uint64]*memoryUnacked),
+	}
+}
+
+func (s *MemoryServer) generateName(prefix string) string {
+	s.generated++
+	return fmt.Sprintf("%s-%d", prefix, s.generated)
+}
+
+// route returns the queues a message published to exchange with key ends up in
+func (s *MemoryServer) route(exchange, key string) ([]*memoryQueue, error) {
+	if exchange == "" {
+		if q := s.queues[key]; q != nil {
+			return []*memoryQueue{q}, nil
+		}
+		// just like RabbitMQ, a message for a queue nobody declared is dropped
+		return nil, nil
+	}
+
+	ex := s.exchanges[exchange]
+	if ex == nil {
+		return nil, notFound("exchange", exchange)
+	}
+
+	seen := make(map[string]bool)
+	queues := []*memoryQueue{}
+	for _, b := range ex.bindings {
+		if seen[b.queue] || !bindingMatches(ex.kind, b.key, key) {
+			continue
+		}
+		seen[b.queue] = true
+		queues = append(queues, s.queues[b.queue])
+	}
+
+	return queues, nil
+}
+
+func bindingMatches(kind, bindingKey, routingKey string) bool {
+	switch kind {
+	case FanoutExchange:
+		return true
+	case DirectExchange:
+		return bindingKey == routingKey
+	case TopicExchange:
+		return TopicMatches(bindingKey, routingKey)
+	}
+
+	return false
+}
+
+// TopicMatches tells whether a routing key matches the binding key of a topic exchange,
+// both are dot separated words and in the binding key "*" stands for exactly one word and "#" for zero or more
+func TopicMatches(bindingKey, routingKey string) bool {
+	return topicMatches(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+}
+
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	if pattern[0] == "#" {
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(words) == 0 || (pattern[0] != "*" && pattern[0] != words[0]) {
+		return false
+	}
+
+	return topicMatches(pattern[1:], words[1:])
+}
+
+func (s *MemoryServer) enqueue(q *memoryQueue, m memoryMessage, front bool) {
+	// a requeue into a queue that has been auto-deleted in the meantime is dropped
+	if s.queues[q.name] != q {
+		return
+	}
+
+	// a requeued message keeps the expiry it got when it first arrived
+	if ttl, ok := q.ttl(); ok && m.expires.IsZero() {
+		m.expires = time.Now().Add(ttl)
+		time.AfterFunc(ttl, func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			s.expire(q)
+		})
+	}
+
+	if front {
+		q.messages = append([]memoryMessage{m}, q.messages...)
+	} else {
+		q.messages = append(q.messages, m)
+	}
+
+	q.cond.Broadcast()
+}
+
+// ttl returns the x-message-ttl argument of the queue
+func (q *memoryQueue) ttl() (time.Duration, bool) {
+	if _, ok := q.args[messageTTLArg]; !ok {
+		return 0, false
+	}
+
+	return time.Duration(headerInt(q.args, messageTTLArg)) * time.Millisecond, true
+}
+
+// expire dead-letters the messages of the queue whose time is up
+func (s *MemoryServer) expire(q *memoryQueue) {
+	if s.queues[q.name] != q {
+		return
+	}
+
+	now := time.Now()
+	messages := q.messages[:0]
+	for _, m := range q.messages {
+		if !m.expires.IsZero() && !now.Before(m.expires) {
+			s.deadLetter(q, m, "expired")
+		} else {
+			messages = append(messages, m)
+		}
+	}
+	q.messages = messages
+}
+
+// deadLetter republishes a message that was rejected or has expired to the queue's dead-letter exchange,
+// without one the message is dropped
+func (s *MemoryServer) deadLetter(q *memoryQueue, m memoryMessage, reason string) {
+	exchange, ok := q.args[deadLetterExchangeArg].(string)
+	if !ok {
+		return
+	}
+
+	key := m.key
+	if k, ok := q.args[deadLetterRoutingKeyArg].(string); ok {
+		key = k
+	}
+
+	msg := m.msg
+	msg.Headers = amqp.Table{}
+	for k, v := range m.msg.Headers {
+		msg.Headers[k] = v
+	}
+	msg.Headers[deathHeader] = addDeath(m.msg.Headers[deathHeader], q.name, reason, m)
+
+	// !!! like RabbitMQ, a dead-letter exchange that doesn't exist drops the message silently
+	queues, _ := s.route(exchange, key)
+	for _, dq := range queues {
+		s.enqueue(dq, memoryMessage{exchange: exchange, key: key, msg: msg}, false)
+	}
+}
+
+// addDeath counts one more death of the message in the x-death header, an entry per queue and reason, the latest first
+func addDeath(header interface{}, queue, reason string, m memoryMessage) []interface{} {
+	entries, _ := header.([]interface{})
+
+	death := amqp.Table{
+		"queue":        queue,
+		"reason":       reason,
+		"exchange":     m.exchange,
+		"routing-keys": []interface{}{m.key},
+		"time":         time.Now(),
+		"count":        int64(1),
+	}
+
+	deaths := []interface{}{death}
+	for _, e := range entries {
+		t, ok := e.(amqp.Table)
+		if ok && t["queue"] == queue && t["reason"] == reason {
+			death["count"] = headerInt(t, "count") + 1
+			continue
+		}
+		deaths = append(deaths, e)
+	}
+
+	return deaths
+}
+
+func (s *MemoryServer) deleteQueue(q *memoryQueue) {
+	delete(s.queues, q.name)
+	q.messages = nil
+
+	for _, ex := range s.exchanges {
+		bindings := ex.bindings[:0]
+		for _, b := range ex.bindings {
+			if b.queue != q.name {
+				bindings = append(bindings, b)
+			}
+		}
+		ex.bindings = bindings
+	}
+}
+
+func notFound(kind, name string) error {
+	return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no %s '%s'", kind, name)}
+}
+
+// memoryBroker is one client of a MemoryServer, all of its state is guarded by the server's mutex
+type memoryBroker struct {
+	server      *MemoryServer
+	consumers   map[string]*memoryConsumer
+	unacked     map[uint64]*memoryUnacked
+	deliveryTag uint64
+	closed      bool
+}
+
+type memoryConsumer struct {
+	tag        string
+	queue      *memoryQueue
+	broker     *memoryBroker
+	autoAck    bool
+	exclusive  bool
+	cancelled  bool
+	deliveries chan amqp.Delivery
+	done       chan struct{} // closed on cancel, so a consumer blocked on sending gives up
+}
+
+type memoryUnacked struct {
+	queue   *memoryQueue
+	message memoryMessage
+}
+
+func (b *memoryBroker) DeclareQueue(name string, autoDelete bool, args amqp.Table) (string, error) {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return "", amqp.ErrClosed
+	}
+
+	if name == "" {
+		name = s.generateName("amq.gen")
+	}
+
+	if q := s.queues[name]; q != nil && !sameArgs(q.args, args) {
+		return "", &amqp.Error{Code: amqp.PreconditionFailed,
+			Reason: "PRECONDITION_FAILED - inequivalent args for queue '" + name + "'"}
+	}
+
+	if s.queues[name] == nil {
+		q := memoryQueue{
+			name:       name,
+			autoDelete: autoDelete,
+			args:       args,
+		}
+		q.cond = sync.NewCond(&s.mutex)
+		s.queues[name] = &q
+	}
+
+	return name, nil
+}
+
+func sameArgs(a, b amqp.Table) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func (b *memoryBroker) DeclareExchange(name, kind string) error {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return amqp.ErrClosed
+	}
+
+	if kind != FanoutExchange && kind != DirectExchange && kind != TopicExchange {
+		return &amqp.Error{Code: amqp.CommandInvalid, Reason: "COMMAND_INVALID - unknown exchange type '" + kind + "'"}
+	}
+
+	if ex := s.exchanges[name]; ex != nil {
+		if ex.kind != kind {
+			return &amqp.Error{Code: amqp.PreconditionFailed,
+				Reason: "PRECONDITION_FAILED - inequivalent arg 'type' for exchange '" + name + "'"}
+		}
+		return nil
+	}
+
+	s.exchanges[name] = &memoryExchange{kind: kind}
+	return nil
+}
+
+func (b *memoryBroker) BindQueue(queue, key, exchange string) error {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return amqp.ErrClosed
+	}
+
+	if exchange == "" {
+		return &amqp.Error{Code: amqp.AccessRefused, Reason: "ACCESS_REFUSED - operation not permitted on the default exchange"}
+	}
+
+	ex := s.exchanges[exchange]
+	if ex == nil {
+		return notFound("exchange", exchange)
+	}
+
+	if s.queues[queue] == nil {
+		return notFound("queue", queue)
+	}
+
+	binding := memoryBinding{queue: queue, key: key}
+	for _, existing := range ex.bindings {
+		if existing == binding {
+			return nil
+		}
+	}
+
+	ex.bindings = append(ex.bindings, binding)
+	return nil
+}
+
+func (b *memoryBroker) Publish(exchange, key string, msg amqp.Publishing) error {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return amqp.ErrClosed
+	}
+
+	queues, err := s.route(exchange, key)
+	if err != nil {
+		return err
+	}
+
+	// !!! publishers reuse their buffers (see sensor.go), so the body has to be copied
+	msg.Body = append([]byte(nil), msg.Body...)
+	for _, q := range queues {
+		s.enqueue(q, memoryMessage{exchange: exchange, key: key, msg: msg}, false)
+	}
+
+	return nil
+}
+
+// PublishConfirmed is confirmed straight away, as soon as the message sits in its queues
+func (b *memoryBroker) PublishConfirmed(exchange, key string, msg amqp.Publishing) (<-chan error, error) {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	queues, err := s.route(exchange, key)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	if len(queues) == 0 {
+		done <- ErrUnroutable
+		return done, nil
+	}
+
+	msg.Body = append([]byte(nil), msg.Body...)
+	for _, q := range queues {
+		s.enqueue(q, memoryMessage{exchange: exchange, key: key, msg: msg}, false)
+	}
+
+	done <- nil
+	return done, nil
+}
+
+func (b *memoryBroker) Consume(queue, consumer string, autoAck, exclusive bool) (<-chan amqp.Delivery, error) {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	q := s.queues[queue]
+	if q == nil {
+		return nil, notFound("queue", queue)
+	}
+
+	if len(q.consumers) > 0 && (exclusive || q.consumers[0].exclusive) {
+		return nil, &amqp.Error{Code: amqp.AccessRefused,
+			Reason: "ACCESS_REFUSED - queue '" + queue + "' in exclusive use"}
+	}
+
+	if consumer == "" {
+		consumer = s.generateName("ctag")
+	}
+
+	if b.consumers[consumer] != nil {
+		return nil, &amqp.Error{Code: amqp.NotAllowed, Reason: "NOT_ALLOWED - attempt to reuse consumer tag '" + consumer + "'"}
+	}
+
+	c := memoryConsumer{
+		tag:        consumer,
+		queue:      q,
+		broker:     b,
+		autoAck:    autoAck,
+		exclusive:  exclusive,
+		deliveries: make(chan amqp.Delivery),
+		done:       make(chan struct{}),
+	}
+
+	b.consumers[consumer] = &c
+	q.consumers = append(q.consumers, &c)
+	q.hadConsumer = true
+
+	go c.run()
+
+	return c.deliveries, nil
+}
+
+// run hands the queue's messages to the consumer one at a time,
+// several consumers on the same queue compete for the messages like they do on RabbitMQ
+func (c *memoryConsumer) run() {
+	s := c.broker.server
+	defer close(c.deliveries)
+
+	for {
+		s.mutex.Lock()
+		for len(c.queue.messages) == 0 && !c.cancelled {
+			c.queue.cond.Wait()
+		}
+
+		if c.cancelled {
+			s.mutex.Unlock()
+			return
+		}
+
+		m := c.queue.messages[0]
+		c.queue.messages = c.queue.messages[1:]
+
+		c.broker.deliveryTag++
+		tag := c.broker.deliveryTag
+		if !c.autoAck {
+			c.broker.unacked[tag] = &memoryUnacked{queue: c.queue, message: m}
+		}
+		s.mutex.Unlock()
+
+		select {
+		case c.deliveries <- m.delivery(c.broker, c.tag, tag):
+		case <-c.done:
+			// cancelled before anybody took the message, so it goes back untouched
+			// (unless closing the broker has requeued it already)
+			s.mutex.Lock()
+			if _, pending := c.broker.unacked[tag]; pending || c.autoAck {
+				delete(c.broker.unacked, tag)
+				s.enqueue(c.queue, m, true)
+			}
+			s.mutex.Unlock()
+			return
+		}
+	}
+}
+
+func (m memoryMessage) delivery(b *memoryBroker, consumer string, tag uint64) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger:    b,
+		Headers:         m.msg.Headers,
+		ContentType:     m.msg.ContentType,
+		ContentEncoding: m.msg.ContentEncoding,
+		DeliveryMode:    m.msg.DeliveryMode,
+		Priority:        m.msg.Priority,
+		CorrelationId:   m.msg.CorrelationId,
+		ReplyTo:         m.msg.ReplyTo,
+		Expiration:      m.msg.Expiration,
+		MessageId:       m.msg.MessageId,
+		Timestamp:       m.msg.Timestamp,
+		Type:            m.msg.Type,
+		UserId:          m.msg.UserId,
+		AppId:           m.msg.AppId,
+		ConsumerTag:     consumer,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.key,
+		Body:            m.msg.Body,
+	}
+}
+
+func (b *memoryBroker) Cancel(consumer string) error {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c := b.consumers[consumer]
+	if c == nil {
+		return notFound("consumer", consumer)
+	}
+
+	b.cancel(c)
+	return nil
+}
+
+// cancel stops the consumer, its unacked messages stay with the broker until they're acked or the broker is closed
+func (b *memoryBroker) cancel(c *memoryConsumer) {
+	s := b.server
+
+	c.cancelled = true
+	close(c.done)
+	delete(b.consumers, c.tag)
+
+	q := c.queue
+	for i := range q.consumers {
+		if q.consumers[i] == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	q.cond.Broadcast()
+
+	if q.autoDelete && q.hadConsumer && len(q.consumers) == 0 {
+		s.deleteQueue(q)
+	}
+}
+
+// Ack implements amqp.Acknowledger for the deliveries handed out by this broker
+func (b *memoryBroker) Ack(tag uint64, multiple bool) error {
+	return b.settle(tag, multiple, false, false)
+}
+
+// Nack implements amqp.Acknowledger
+func (b *memoryBroker) Nack(tag uint64, multiple bool, requeue bool) error {
+	return b.settle(tag, multiple, true, requeue)
+}
+
+// Reject implements amqp.Acknowledger
+func (b *memoryBroker) Reject(tag uint64, requeue bool) error {
+	return b.settle(tag, false, true, requeue)
+}
+
+// settle forgets about the delivery (or every delivery up to it when multiple is set),
+// putting the messages back in front of their queues when requeue is set,
+// rejected messages that aren't requeued go to the queue's dead-letter exchange
+func (b *memoryBroker) settle(tag uint64, multiple bool, rejected bool, requeue bool) error {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return amqp.ErrClosed
+	}
+
+	tags := []uint64{}
+	if multiple {
+		for t := range b.unacked {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+	} else if b.unacked[tag] != nil {
+		tags = append(tags, tag)
+	}
+
+	if len(tags) == 0 && !multiple {
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag)}
+	}
+
+	b.requeue(tags, requeue, rejected)
+	return nil
+}
+
+// requeue drops the given unacked deliveries, sending them back to their queues if requeue is set
+// or on to the dead-letter exchange if they have been rejected
+func (b *memoryBroker) requeue(tags []uint64, requeue bool, rejected bool) {
+	// go from the newest to the oldest, so the messages end up in front of the queue in their original order
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+
+	for _, t := range tags {
+		u := b.unacked[t]
+		delete(b.unacked, t)
+
+		if requeue {
+			u.message.redelivered = true
+			b.server.enqueue(u.queue, u.message, true)
+		} else if rejected {
+			b.server.deadLetter(u.queue, u.message, "rejected")
+		}
+	}
+}
+
+// Close cancels every consumer of this client and requeues whatever it left unacked
+func (b *memoryBroker) Close() error {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	for _, c := range b.consumers {
+		b.cancel(c)
+	}
+
+	tags := []uint64{}
+	for t := range b.unacked {
+		tags = append(tags, t)
+	}
+	b.requeue(tags, true, false)
+
+	b.closed = true
+	return nil
+}
+
+// Get takes the first message off the queue, like a consumer that only wants one
+func (b *memoryBroker) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+
+	q := s.queues[queue]
+	if q == nil {
+		return amqp.Delivery{}, false, notFound("queue", queue)
+	}
+
+	if len(q.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+
+	m := q.messages[0]
+	q.messages = q.messages[1:]
+
+	b.deliveryTag++
+	tag := b.deliveryTag
+	if !autoAck {
+		b.unacked[tag] = &memoryUnacked{queue: q, message: m}
+	}
+
+	return m.delivery(b, "", tag), true, nil
+}
+
+// Purge drops the messages waiting in the queue, the unacked ones stay with their consumers
+func (b *memoryBroker) Purge(queue string) (int, error) {
+	s := b.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if b.closed {
+		return 0, amqp.ErrClosed
+	}
+
+	q := s.queues[queue]
+	if q == nil {
+		return 0, notFound("queue", queue)
+	}
+
+	n := len(q.messages)
+	q.messages = nil
+	return n, nil
+}
@@ -0,0 +1,291 @@
+package queueutils
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// bodies takes the messages waiting in queue, and returns their bodies
+func bodies(t *testing.T, b Broker, queue string) []string {
+	t.Helper()
+
+	got := []string{}
+	for {
+		msg, ok, err := b.(QueueInspector).Get(queue, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return got
+		}
+		got = append(got, string(msg.Body))
+	}
+}
+
+func expectBodies(t *testing.T, b Broker, queue string, want ...string) {
+	t.Helper()
+
+	if got := bodies(t, b, queue); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("%s: expected %v, got %v", queue, want, got)
+	}
+}
+
+func publishBody(t *testing.T, b Broker, exchange, key, body string) {
+	t.Helper()
+
+	if err := b.Publish(exchange, key, amqp.Publishing{Body: []byte(body)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func declare(t *testing.T, b Broker, name string, autoDelete bool) string {
+	t.Helper()
+
+	q, err := b.DeclareQueue(name, autoDelete, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func isAMQPError(err error, code int) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == code
+}
+
+func TestMemoryDefaultExchange(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+
+	declare(t, b, "readings", false)
+	declare(t, b, "alarms", false)
+
+	// the routing key names the queue, one nobody declared drops the message
+	publishBody(t, b, "", "readings", "1")
+	publishBody(t, b, "", "nobody", "2")
+
+	expectBodies(t, b, "readings", "1")
+	expectBodies(t, b, "alarms")
+
+	if err := b.BindQueue("readings", "readings", ""); !isAMQPError(err, amqp.AccessRefused) {
+		t.Fatalf("expected binding to the default exchange to be refused, got %v", err)
+	}
+}
+
+func TestMemoryFanout(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+
+	if err := b.DeclareExchange("sources", FanoutExchange); err != nil {
+		t.Fatal(err)
+	}
+	first := declare(t, b, "", true)
+	second := declare(t, b, "", true)
+	unbound := declare(t, b, "", true)
+	if first == second {
+		t.Fatalf("expected the generated names to differ, got %s twice", first)
+	}
+	for _, q := range []string{first, second} {
+		if err := b.BindQueue(q, "", "sources"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the routing key doesn't matter, and the default exchange doesn't go to the bound queues
+	publishBody(t, b, "sources", "", "1")
+	publishBody(t, b, "sources", "whatever", "2")
+	publishBody(t, b, "", first, "3")
+
+	expectBodies(t, b, first, "1", "2", "3")
+	expectBodies(t, b, second, "1", "2")
+	expectBodies(t, b, unbound)
+
+	if err := b.Publish("nowhere", "", amqp.Publishing{}); !isAMQPError(err, amqp.NotFound) {
+		t.Fatalf("expected an exchange that doesn't exist to be refused, got %v", err)
+	}
+	if err := b.DeclareExchange("sources", TopicExchange); !isAMQPError(err, amqp.PreconditionFailed) {
+		t.Fatalf("expected the exchange not to change kind, got %v", err)
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	for _, c := range []struct {
+		binding, key string
+		want         bool
+	}{
+		{"10s.*", "10s.boiler_pressure_out", true},
+		{"10s.*", "1m.boiler_pressure_out", false},
+		{"10s.*", "10s", false},
+		{"*", "boiler", true},
+		{"*", "rule.overheat", false},
+		{"#", "boiler", true},
+		{"#", "rule.overheat", true},
+		{"#", "", true},
+		{"rule.#", "rule", true},
+		{"rule.#", "rule.overheat", true},
+		{"#.overheat", "rule.overheat", true},
+		{"a.#.d", "a.b.c.d", true},
+		{"a.#.d", "a.d", true},
+		{"a.#.d", "a.b.c", false},
+		{"a.*.d", "a.b.c.d", false},
+		{"boiler", "boiler", true},
+		{"boiler", "boiler2", false},
+	} {
+		if got := TopicMatches(c.binding, c.key); got != c.want {
+			t.Errorf("%q with %q: expected %v, got %v", c.binding, c.key, c.want, got)
+		}
+	}
+}
+
+func TestMemoryTopicAndDirect(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+
+	b.DeclareExchange("aggregates", TopicExchange)
+	b.DeclareExchange("deadletters", DirectExchange)
+
+	seconds := declare(t, b, "seconds", false)
+	boiler := declare(t, b, "boiler", false)
+	retries := declare(t, b, "retries", false)
+	b.BindQueue(seconds, "1s.*", "aggregates")
+	b.BindQueue(boiler, "*.boiler", "aggregates")
+	b.BindQueue(boiler, "#.boiler", "aggregates") // a message matching both bindings still goes once
+	b.BindQueue(retries, "retry", "deadletters")
+
+	publishBody(t, b, "aggregates", "1s.boiler", "1")
+	publishBody(t, b, "aggregates", "1s.turbine", "2")
+	publishBody(t, b, "aggregates", "1m.boiler", "3")
+	publishBody(t, b, "deadletters", "retry", "4")
+	publishBody(t, b, "deadletters", "dead", "5")
+
+	expectBodies(t, b, seconds, "1", "2")
+	expectBodies(t, b, boiler, "1", "3")
+	expectBodies(t, b, retries, "4")
+}
+
+func TestMemoryAutoDelete(t *testing.T) {
+	s := NewMemoryServer()
+	b := s.Connect()
+	defer b.Close()
+
+	q := declare(t, b, "", true)
+
+	// it stays until it has had a consumer
+	publishBody(t, b, "", q, "1")
+	msgs, err := b.Consume(q, "watcher", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if string(msg.Body) != "1" {
+			t.Fatalf("expected 1, got %s", msg.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message sent before the consumer came")
+	}
+
+	if err := b.Cancel("watcher"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.(QueueInspector).Get(q, true); !isAMQPError(err, amqp.NotFound) {
+		t.Fatalf("expected the queue to be gone with its last consumer, got %v", err)
+	}
+
+	// a queue that isn't auto-delete outlives its consumers
+	kept := declare(t, b, "kept", false)
+	if _, err := b.Consume(kept, "watcher", true, false); err != nil {
+		t.Fatal(err)
+	}
+	b.Cancel("watcher")
+	publishBody(t, b, "", kept, "2")
+	expectBodies(t, b, kept, "2")
+}
+
+func TestMemoryExclusiveConsumer(t *testing.T) {
+	s := NewMemoryServer()
+	first, second := s.Connect(), s.Connect()
+	defer first.Close()
+	defer second.Close()
+
+	q := declare(t, first, "PersistReadings", false)
+
+	if _, err := first.Consume(q, "writer", false, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Consume(q, "", false, false); !isAMQPError(err, amqp.AccessRefused) {
+		t.Fatalf("expected the queue to be in exclusive use, got %v", err)
+	}
+	if _, err := second.Consume(q, "", false, true); !isAMQPError(err, amqp.AccessRefused) {
+		t.Fatalf("expected the queue to be in exclusive use, got %v", err)
+	}
+
+	// once the first one is gone, the next one gets it
+	first.Close()
+	if _, err := second.Consume(q, "", false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// and an exclusive one can't join an ordinary one
+	other := declare(t, second, "other", false)
+	if _, err := second.Consume(other, "", false, false); err != nil {
+		t.Fatal(err)
+	}
+	third := s.Connect()
+	defer third.Close()
+	if _, err := third.Consume(other, "", false, true); !isAMQPError(err, amqp.AccessRefused) {
+		t.Fatalf("expected an exclusive consumer to be refused next to another one, got %v", err)
+	}
+}
+
+func TestMemoryCompetingConsumersAndRequeue(t *testing.T) {
+	s := NewMemoryServer()
+	b := s.Connect()
+	defer b.Close()
+	q := declare(t, b, "work", false)
+
+	first, second := s.Connect(), s.Connect()
+	defer second.Close()
+	firstMsgs, _ := first.Consume(q, "", false, false)
+	secondMsgs, _ := second.Consume(q, "", false, false)
+
+	for _, body := range []string{"1", "2", "3", "4"} {
+		publishBody(t, b, "", q, body)
+	}
+
+	// every message goes to one of them
+	got := []string{}
+	var unacked amqp.Delivery
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-firstMsgs:
+			got = append(got, string(msg.Body))
+			unacked = msg
+		case msg := <-secondMsgs:
+			got = append(got, string(msg.Body))
+			msg.Ack(false)
+		case <-time.After(time.Second):
+			t.Fatalf("expected 4 messages, got %v", got)
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "1,2,3,4" {
+		t.Fatalf("expected every message once, got %v", got)
+	}
+
+	// what a closed client left unacked goes back to the queue
+	second.Close()
+	first.Close()
+	if unacked.Body == nil {
+		return
+	}
+	left := bodies(t, b, q)
+	if len(left) == 0 || left[len(left)-1] != string(unacked.Body) {
+		t.Fatalf("expected %s back in the queue, got %v", unacked.Body, left)
+	}
+}
@@ -3,55 +3,75 @@ package queueutils
 import (
 	"fmt"
 	"log"
-
-	"github.com/streadway/amqp"
 )
 
+// !!! the queue and exchange names below are only defaults, config.Apply sets them from the configuration at startup
+
 // SensorListQueue is the queue to record all sensor queues' names
-const SensorListQueue = "SensorList"
+var SensorListQueue = "SensorList"
 
 // SensorDiscoveryExchange is the exchange name for queue listeners to discover previous started data queues if the coordinator started after the data queues
-const SensorDiscoveryExchange = "SensorDiscovery"
-const PersistReadingsQueue = "PersistReadings"
+var SensorDiscoveryExchange = "SensorDiscovery"
+var PersistReadingsQueue = "PersistReadings"
+
+// SensorHeartbeatExchange is where the sensors send their heartbeats to, see dto.Heartbeat
+var SensorHeartbeatExchange = "SensorHeartbeats"
+
+// SensorRegistrationExchange tells everybody caching the sensor table that a sensor has been added to it,
+// the body is the name of the sensor
+var SensorRegistrationExchange = "SensorRegistrations"
+
+// AlarmsExchange carries the dto.AlarmTransition of the sensors and rules, it's a topic exchange
+// and the routing key is the alarm, see dto.AlarmTransition.Alarm
+var AlarmsExchange = "Alarms"
+
+// AlarmActionsExchange carries the dto.AlarmAction of the operators from the web applications, it's a fanout exchange
+var AlarmActionsExchange = "AlarmActions"
+
+// PersistAlarmsQueue is bound to AlarmsExchange and AlarmActionsExchange for the data manager
+// to keep every alarm transition and action
+var PersistAlarmsQueue = "PersistAlarms"
 
 // WebappSourceExchange is used to broadcast the name of the sensors
-const WebappSourceExchange = "WebappSources"
+var WebappSourceExchange = "WebappSources"
 
 // WebappReadingsExchange is used to send out readings to the web applications.
-const WebappReadingsExchange = "WebappReadings"
+var WebappReadingsExchange = "WebappReadings"
 
 // WebappDiscoveryQueue is used by the web applications to let the coordinator know
 // 	that one of them would like to get a list of all of the available sources.
-const WebappDiscoveryQueue = "WebappDiscovery"
+var WebappDiscoveryQueue = "WebappDiscovery"
 
-// event names
-const DataSourceDiscoveredEvent = "DataSourceDiscovered"
-const MessageReceivedEvent = "MessageReceived_"
+// AggregatesExchange carries the dto.Aggregate of the windows of the coordinator, it's a topic exchange
+// and the routing key is the name of the window and the sensor, like "10s.boiler_pressure_out"
+var AggregatesExchange = "Aggregates"
 
-// GetChannel returns the connection and channel from RabbitMQ
-func GetChannel(url string) (*amqp.Connection, *amqp.Channel) {
-	conn, err := amqp.Dial(url)
-	failOnError(err, "Failed to establish connection to message broker.")
+// NotificationsQueue is bound to AlarmsExchange for the notifiers to send the alarm transitions on to their sinks
+var NotificationsQueue = "Notifications"
 
-	ch, err := conn.Channel()
-	failOnError(err, "Failed to get channel for connection")
+// types of the messages on WebappSourceExchange, the body is the name of the source,
+// a message without a type announces a source, and one of type dto.HeartbeatType carries its metadata
+const (
+	SourceLostMessage      = "SourceLost"
+	SourceRecoveredMessage = "SourceRecovered"
+)
+
+// GetBroker returns a broker connected to url, "memory://..." urls get the in-process broker
+func GetBroker(url string) Broker {
+	broker, err := Dial(url)
+	failOnError(err, "Failed to establish connection to message broker.")
 
-	return conn, ch
+	return broker
 }
 
-// GetQueue returns a queue from RabbitMQ
-func GetQueue(name string, ch *amqp.Channel, autoDelete bool) *amqp.Queue {
-	q, err := ch.QueueDeclare(
-		name,  //name string,
-		false, //durable bool,
-		// !!! true will clean up the temp queues
-		autoDelete, //autoDelete bool,
-		false,      //exclusive bool,
-		false,      //noWait bool,
-		nil)        //args amqp.Table)
+// GetQueue declares a queue on the broker and returns its name,
+// an empty name lets the broker generate a unique one
+func GetQueue(name string, broker Broker, autoDelete bool) string {
+	// !!! autoDelete true will clean up the temp queues
+	q, err := broker.DeclareQueue(name, autoDelete, nil)
 
 	failOnError(err, "Failed to declare a queue")
-	return &q
+	return q
 }
 
 func failOnError(err error, msg string) {
@@ -0,0 +1,156 @@
+package queueutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrPublishBufferFull is returned when the broker has been away long enough for the local buffer to fill up
+var ErrPublishBufferFull = errors.New("publish buffer is full")
+
+// ErrPublisherClosed is returned by Publish once Close has been called
+var ErrPublisherClosed = errors.New("publisher is closed")
+
+// DefaultPublishBufferSize is how many unconfirmed messages a ReliablePublisher keeps by default
+const DefaultPublishBufferSize = 10000
+
+// confirmTimeout is how long to wait for the broker to confirm a message before publishing it again
+const confirmTimeout = 30 * time.Second
+
+/*
+ReliablePublisher is a Publisher that doesn't lose messages when the broker is briefly unavailable.
+
+Publish only puts the message into a local buffer, a goroutine then publishes the buffered messages
+one after the other in confirm mode and as mandatory, and keeps publishing each one again with
+backoff until the broker confirms it has been routed to a queue. So the order is kept, and a message
+only leaves the buffer once it's safely with the broker.
+*/
+type ReliablePublisher struct {
+	broker  ConfirmPublisher
+	backoff Backoff
+	pending chan pendingPublish
+
+	mutex     sync.RWMutex // guards closing pending against Publish
+	closed    bool
+	done      chan struct{} // closed once run has published everything
+	abort     chan struct{} // closed when Close gives up on the rest
+	abortOnce sync.Once
+	lost      int // set by run when it gave up
+}
+
+type pendingPublish struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+// NewReliablePublisher returns a publisher buffering up to bufferSize unconfirmed messages,
+// the broker has to support confirms, which both backends do
+func NewReliablePublisher(broker Broker, bufferSize int) (*ReliablePublisher, error) {
+	confirmer, ok := broker.(ConfirmPublisher)
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't support publisher confirms", broker)
+	}
+
+	p := ReliablePublisher{
+		broker:  confirmer,
+		backoff: ReconnectBackoff,
+		pending: make(chan pendingPublish, bufferSize),
+		done:    make(chan struct{}),
+		abort:   make(chan struct{}),
+	}
+
+	go p.run()
+
+	return &p, nil
+}
+
+// Publish buffers the message, it only fails when the buffer is full
+func (p *ReliablePublisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	// !!! publishers reuse their buffers (see sensor.go), and this one is published later on
+	msg.Body = append([]byte(nil), msg.Body...)
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	select {
+	case p.pending <- pendingPublish{exchange: exchange, key: key, msg: msg}:
+		return nil
+	default:
+		return ErrPublishBufferFull
+	}
+}
+
+// Close stops taking messages and waits until the buffered ones are confirmed or ctx is done,
+// in which case the rest is dropped and the error says how many were lost
+func (p *ReliablePublisher) Close(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.pending)
+	}
+	p.mutex.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.abortOnce.Do(func() { close(p.abort) })
+		<-p.done
+	}
+
+	if p.lost > 0 {
+		return fmt.Errorf("%d messages weren't confirmed before shutting down: %s", p.lost, ctx.Err())
+	}
+	return nil
+}
+
+func (p *ReliablePublisher) run() {
+	defer close(p.done)
+
+	for pub := range p.pending {
+		for attempt := 0; ; attempt++ {
+			err := p.publish(pub)
+			if err == nil {
+				break
+			}
+
+			delay := p.backoff.Delay(attempt)
+			log.Printf("Failed to publish message to '%s' (key '%s'), retrying in %v: %s\n",
+				pub.exchange, pub.key, delay, err)
+
+			select {
+			case <-time.After(delay):
+			case <-p.abort:
+				p.lost = 1 + len(p.pending)
+				return
+			}
+		}
+	}
+}
+
+func (p *ReliablePublisher) publish(pub pendingPublish) error {
+	confirmed, err := p.broker.PublishConfirmed(pub.exchange, pub.key, pub.msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-confirmed:
+		return err
+	case <-time.After(confirmTimeout):
+		return errors.New("timed out waiting for the broker to confirm")
+	case <-p.abort:
+		return errors.New("gave up waiting for the broker to confirm")
+	}
+}
@@ -1,7 +1,21 @@
 package main
 
-import "github.com/golang-distributed-application/src/powerplant/sensors"
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/golang-distributed-application/src/powerplant/sensors"
+)
 
 func main() {
-	sensor.StartPublishingSensorData()
+	// runs until it's interrupted or terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := sensor.StartPublishingSensorData(ctx); err != nil {
+		log.Fatalf("Sensor stopped: %s", err)
+	}
 }
@@ -0,0 +1,598 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the files of a FileStore
+const (
+	sensorsFile  = "sensors.jsonl"
+	readingsFile = "readings.jsonl"
+	alarmsFile   = "alarms.jsonl"
+	actionsFile  = "actions.jsonl"
+)
+
+/*
+FileStore runs the plant without Postgres, it keeps the sensors and readings in a directory of append-only files
+with a JSON object per line. A batch of readings is appended with a single write and synced before SaveReadings
+returns, a batch that's only partly written (the disk is full, the process dies) is cut off again, when the write
+fails or before the next one.
+
+!!! only one process may write to a store, others (the web application) may read from it, they see the sensors
+added by the writer as they ask for them. The sensors file may be edited by hand, e.g. the safe range of a sensor,
+every process loads it again once it has changed.
+
+It has no rollups and keeps every reading, it's no RetentionStore.
+*/
+type FileStore struct {
+	mutex        sync.Mutex
+	sensors      map[string]Sensor
+	ids          map[int]bool
+	lastID       int
+	sensorsFile  *os.File
+	sensorsPath  string
+	sensorsRead  int64       // how much of the sensors file has been loaded
+	sensorsInfo  os.FileInfo // of the sensors file when it was loaded, see loadSensors
+	readingsFile *os.File
+	readingsSize int64
+	alarmsFile   *os.File
+	alarmsSize   int64
+	alarms       map[fileAlarmKey]bool // the transitions saved already, to skip the ones delivered twice
+	actionsFile  *os.File
+	actionsSize  int64
+	actions      map[fileActionKey]bool // like alarms
+}
+
+type fileSensor struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	SerialNo     string  `json:"serialNo"`
+	UnitType     string  `json:"unitType"`
+	MinSafeValue float64 `json:"minSafeValue"`
+	MaxSafeValue float64 `json:"maxSafeValue"`
+}
+
+type fileAlarm struct {
+	Sensor       string    `json:"sensor"`
+	Rule         string    `json:"rule,omitempty"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Value        float64   `json:"value"`
+	MinSafeValue float64   `json:"minSafeValue"`
+	MaxSafeValue float64   `json:"maxSafeValue"`
+	TakenOn      time.Time `json:"takenOn"`
+}
+
+// fileAlarmKey tells the transitions apart, like the unique key of the alarm_transition table
+type fileAlarmKey struct {
+	sensor  string
+	rule    string
+	takenOn int64
+	to      string
+}
+
+type fileAction struct {
+	Alarm        string    `json:"alarm"`
+	Kind         string    `json:"kind"`
+	Operator     string    `json:"operator"`
+	Comment      string    `json:"comment,omitempty"`
+	ShelvedUntil time.Time `json:"shelvedUntil"`
+	TakenOn      time.Time `json:"takenOn"`
+}
+
+// fileActionKey tells the actions apart, like the unique key of the alarm_action table
+type fileActionKey struct {
+	alarm    string
+	kind     string
+	operator string
+	takenOn  int64
+}
+
+type fileReading struct {
+	SensorID int       `json:"sensorId"`
+	Value    float64   `json:"value"`
+	TakenOn  time.Time `json:"takenOn"`
+}
+
+// OpenFileStore opens the store in dir, creating it if it isn't there
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fs := FileStore{
+		sensors: make(map[string]Sensor),
+		ids:     make(map[int]bool),
+	}
+
+	var err error
+	fs.sensorsPath = filepath.Join(dir, sensorsFile)
+	fs.sensorsFile, err = openFile(fs.sensorsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.readingsFile, err = openFile(filepath.Join(dir, readingsFile))
+	if err != nil {
+		fs.sensorsFile.Close()
+		return nil, err
+	}
+
+	fs.alarmsFile, err = openFile(filepath.Join(dir, alarmsFile))
+	if err != nil {
+		fs.sensorsFile.Close()
+		fs.readingsFile.Close()
+		return nil, err
+	}
+
+	fs.actionsFile, err = openFile(filepath.Join(dir, actionsFile))
+	if err != nil {
+		fs.sensorsFile.Close()
+		fs.readingsFile.Close()
+		fs.alarmsFile.Close()
+		return nil, err
+	}
+
+	if err := fs.loadSensors(); err != nil {
+		fs.Close()
+		return nil, err
+	}
+
+	fs.readingsSize, err = completeLines(fs.readingsFile, 0, nil)
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+
+	fs.alarms = make(map[fileAlarmKey]bool)
+	fs.alarmsSize, err = completeLines(fs.alarmsFile, 0, func(line []byte) error {
+		a := fileAlarm{}
+		if err := json.Unmarshal(line, &a); err != nil {
+			return err
+		}
+
+		fs.alarms[alarmKey(AlarmTransition(a))] = true
+		return nil
+	})
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+
+	fs.actions = make(map[fileActionKey]bool)
+	fs.actionsSize, err = completeLines(fs.actionsFile, 0, func(line []byte) error {
+		a := fileAction{}
+		if err := json.Unmarshal(line, &a); err != nil {
+			return err
+		}
+
+		fs.actions[actionKey(AlarmAction(a))] = true
+		return nil
+	})
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+
+	return &fs, nil
+}
+
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// completeLines calls line for every complete line of f from offset on and returns where the last one ends,
+// anything after it is a write that didn't finish
+func completeLines(f *os.File, offset int64, line func([]byte) error) (int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		if line != nil {
+			if err := line(b); err != nil {
+				return offset, fmt.Errorf("%s at %d: %s", f.Name(), offset, err)
+			}
+		}
+		offset += int64(len(b))
+	}
+}
+
+// loadSensors reads the sensors file again when it has changed since the last time,
+// the sensors added by another process as well as the ones edited by hand
+func (fs *FileStore) loadSensors() error {
+	info, err := os.Stat(fs.sensorsPath)
+	if err != nil {
+		return err
+	}
+	if fs.sensorsInfo != nil && os.SameFile(info, fs.sensorsInfo) &&
+		info.Size() == fs.sensorsInfo.Size() && info.ModTime().Equal(fs.sensorsInfo.ModTime()) {
+		return nil
+	}
+
+	// !!! an editor may have saved it as a new file, the one open would no longer be the sensors file
+	if fs.sensorsInfo != nil && !os.SameFile(info, fs.sensorsInfo) {
+		f, err := openFile(fs.sensorsPath)
+		if err != nil {
+			return err
+		}
+		fs.sensorsFile.Close()
+		fs.sensorsFile = f
+	}
+
+	// an edit can be anywhere in the file, so all of it, the sensors loaded before are kept until it's valid again
+	sensors := make(map[string]Sensor)
+	ids := make(map[int]bool)
+	end, err := completeLines(fs.sensorsFile, 0, func(line []byte) error {
+		s := fileSensor{}
+		if err := json.Unmarshal(line, &s); err != nil {
+			return err
+		}
+
+		sensors[s.Name] = Sensor(s)
+		ids[s.ID] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.sensors, fs.ids = sensors, ids
+	for id := range ids {
+		// lastID only goes up, so no id is given out twice
+		if id > fs.lastID {
+			fs.lastID = id
+		}
+	}
+	fs.sensorsRead = end
+	fs.sensorsInfo = info
+	return nil
+}
+
+func (fs *FileStore) SensorByName(name string) (Sensor, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := fs.loadSensors(); err != nil {
+		return Sensor{}, err
+	}
+
+	s, ok := fs.sensors[name]
+	if !ok {
+		return Sensor{}, fmt.Errorf("sensor '%s' %w", name, ErrNotFound)
+	}
+
+	return s, nil
+}
+
+func (fs *FileStore) Sensors() ([]Sensor, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := fs.loadSensors(); err != nil {
+		return nil, err
+	}
+
+	sensors := make([]Sensor, 0, len(fs.sensors))
+	for _, s := range fs.sensors {
+		sensors = append(sensors, s)
+	}
+	sort.Slice(sensors, func(i, j int) bool {
+		return sensors[i].ID < sensors[j].ID
+	})
+
+	return sensors, nil
+}
+
+func (fs *FileStore) AddSensor(s Sensor) (bool, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if err := fs.loadSensors(); err != nil {
+		return false, err
+	}
+
+	if _, ok := fs.sensors[s.Name]; ok {
+		return false, nil
+	}
+
+	s.ID = fs.lastID + 1
+	line, err := json.Marshal(fileSensor(s))
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrRejected, err)
+	}
+
+	fs.sensorsRead, err = appendLines(fs.sensorsFile, fs.sensorsRead, append(line, '\n'))
+	if err != nil {
+		return false, err
+	}
+
+	fs.sensors[s.Name] = s
+	fs.ids[s.ID] = true
+	fs.lastID = s.ID
+	// its own write isn't a change to load again, without the stat it's loaded once more
+	fs.sensorsInfo, _ = os.Stat(fs.sensorsPath)
+
+	return true, nil
+}
+
+func (fs *FileStore) SaveReadings(readings []Reading) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, r := range readings {
+		if !fs.ids[r.SensorID] {
+			return fmt.Errorf("%w: no sensor with id %d", ErrRejected, r.SensorID)
+		}
+
+		// NaN and infinities don't fit into JSON
+		if err := enc.Encode(fileReading(r)); err != nil {
+			return fmt.Errorf("%w: %s", ErrRejected, err)
+		}
+	}
+
+	var err error
+	fs.readingsSize, err = appendLines(fs.readingsFile, fs.readingsSize, buf.Bytes())
+	return err
+}
+
+func (fs *FileStore) Readings(q ReadingQuery) ([]Reading, error) {
+	readings, err := fs.readingsOf(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Offset >= len(readings) {
+		return []Reading{}, nil
+	}
+	readings = readings[q.Offset:]
+
+	if q.Limit > 0 && q.Limit < len(readings) {
+		readings = readings[:q.Limit]
+	}
+
+	return readings, nil
+}
+
+func (fs *FileStore) Aggregate(q ReadingQuery, step time.Duration, agg Aggregation) ([]Bucket, error) {
+	if _, err := ParseAggregation(string(agg)); err != nil {
+		return nil, err
+	}
+
+	readings, err := fs.readingsOf(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregate(readings, q.From, step, agg), nil
+}
+
+// readingsOf returns the readings q picks, oldest first
+// !!! reads the whole readings file, the file store is meant for small plants
+func (fs *FileStore) readingsOf(q ReadingQuery) ([]Reading, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	readings := []Reading{}
+	_, err := completeLines(fs.readingsFile, 0, func(line []byte) error {
+		r := fileReading{}
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+
+		if r.SensorID == q.SensorID && !r.TakenOn.Before(q.From) && r.TakenOn.Before(q.To) {
+			readings = append(readings, Reading(r))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the readings are saved in the order they arrive, which isn't always the order they're taken in
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].TakenOn.Before(readings[j].TakenOn)
+	})
+
+	return readings, nil
+}
+
+func alarmKey(t AlarmTransition) fileAlarmKey {
+	return fileAlarmKey{sensor: t.Sensor, rule: t.Rule, takenOn: t.TakenOn.UnixNano(), to: t.To}
+}
+
+func (fs *FileStore) SaveAlarmTransition(t AlarmTransition) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.alarms[alarmKey(t)] {
+		return nil
+	}
+
+	line, err := json.Marshal(fileAlarm(t))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRejected, err)
+	}
+
+	fs.alarmsSize, err = appendLines(fs.alarmsFile, fs.alarmsSize, append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	fs.alarms[alarmKey(t)] = true
+	return nil
+}
+
+func (fs *FileStore) AlarmTransitions(q AlarmQuery) ([]AlarmTransition, error) {
+	return fs.alarmTransitions(func(t AlarmTransition) bool {
+		return q.picks(t.Alarm(), t.TakenOn)
+	})
+}
+
+func (fs *FileStore) LatestRaises() ([]AlarmTransition, error) {
+	transitions, err := fs.alarmTransitions(func(t AlarmTransition) bool {
+		return t.From == "Normal"
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]int) // the index of the latest raise of every alarm
+	raises := []AlarmTransition{}
+	for _, t := range transitions {
+		if i, ok := latest[t.Alarm()]; ok {
+			raises[i] = t
+			continue
+		}
+		latest[t.Alarm()] = len(raises)
+		raises = append(raises, t)
+	}
+
+	return raises, nil
+}
+
+// alarmTransitions returns the transitions pick picks, oldest first
+// !!! reads the whole alarms file like readingsOf, there aren't that many
+func (fs *FileStore) alarmTransitions(pick func(AlarmTransition) bool) ([]AlarmTransition, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	transitions := []AlarmTransition{}
+	_, err := completeLines(fs.alarmsFile, 0, func(line []byte) error {
+		a := fileAlarm{}
+		if err := json.Unmarshal(line, &a); err != nil {
+			return err
+		}
+
+		if t := AlarmTransition(a); pick(t) {
+			transitions = append(transitions, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].TakenOn.Before(transitions[j].TakenOn)
+	})
+
+	return transitions, nil
+}
+
+// picks tells whether the query picks what was taken at takenOn about alarm
+func (q AlarmQuery) picks(alarm string, takenOn time.Time) bool {
+	return (q.Alarm == "" || q.Alarm == alarm) && !takenOn.Before(q.From) && takenOn.Before(q.To)
+}
+
+func actionKey(a AlarmAction) fileActionKey {
+	return fileActionKey{alarm: a.Alarm, kind: a.Kind, operator: a.Operator, takenOn: a.TakenOn.UnixNano()}
+}
+
+func (fs *FileStore) SaveAlarmAction(a AlarmAction) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.actions[actionKey(a)] {
+		return nil
+	}
+
+	line, err := json.Marshal(fileAction(a))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRejected, err)
+	}
+
+	fs.actionsSize, err = appendLines(fs.actionsFile, fs.actionsSize, append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	fs.actions[actionKey(a)] = true
+	return nil
+}
+
+func (fs *FileStore) AlarmActions(q AlarmQuery) ([]AlarmAction, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	actions := []AlarmAction{}
+	_, err := completeLines(fs.actionsFile, 0, func(line []byte) error {
+		a := fileAction{}
+		if err := json.Unmarshal(line, &a); err != nil {
+			return err
+		}
+
+		if q.picks(a.Alarm, a.TakenOn) {
+			actions = append(actions, AlarmAction(a))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].TakenOn.Before(actions[j].TakenOn)
+	})
+
+	return actions, nil
+}
+
+// appendLines writes the lines at the end of f, which is size long, and returns the new size,
+// if they can't all be written f is cut back to size
+func appendLines(f *os.File, size int64, lines []byte) (int64, error) {
+	// !!! cuts off what a write that didn't finish has left, only the writing process gets here
+	if err := f.Truncate(size); err != nil {
+		return size, err
+	}
+
+	_, err := f.WriteAt(lines, size)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		f.Truncate(size)
+		return size, err
+	}
+
+	return size + int64(len(lines)), nil
+}
+
+func (fs *FileStore) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	err := fs.sensorsFile.Close()
+	if rerr := fs.readingsFile.Close(); err == nil {
+		err = rerr
+	}
+	if aerr := fs.alarmsFile.Close(); err == nil {
+		err = aerr
+	}
+	if aerr := fs.actionsFile.Close(); err == nil {
+		err = aerr
+	}
+
+	return err
+}
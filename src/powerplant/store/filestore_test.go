@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+	"github.com/golang-distributed-application/src/powerplant/store/storetest"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Run(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// what's in the files is read again, and the ids go on from there
+	s, err = store.OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	t.Run("reopened", func(t *testing.T) {
+		storetest.Run(t, s)
+	})
+}
+
+func TestFileStoreSensorsEdited(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.jsonl")
+
+	writer, err := store.OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	reader, err := store.OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, err := writer.AddSensor(store.Sensor{Name: "boiler", MinSafeValue: 1, MaxSafeValue: 5}); err != nil {
+		t.Fatal(err)
+	}
+	expectRange(t, reader, "boiler", 1, 5)
+
+	// edited in place, then saved as a new file like most editors do
+	edit := func(from, to string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path+".new", []byte(strings.Replace(string(data), from, to, 1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(path+".new", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edit(`"maxSafeValue":5`, `"maxSafeValue":4.5`)
+	expectRange(t, writer, "boiler", 1, 4.5)
+	expectRange(t, reader, "boiler", 1, 4.5)
+
+	// a broken edit is reported until it's fixed
+	edit(`"minSafeValue":1`, `"minSafeValue":`)
+	if _, err := reader.SensorByName("boiler"); err == nil {
+		t.Fatal("expected the broken sensors file to be reported")
+	}
+	edit(`"minSafeValue":`, `"minSafeValue":2`)
+
+	// the writer goes on appending to the new file
+	if _, err := writer.AddSensor(store.Sensor{Name: "turbine", MinSafeValue: 0, MaxSafeValue: 10}); err != nil {
+		t.Fatal(err)
+	}
+	expectRange(t, reader, "boiler", 2, 4.5)
+	expectRange(t, reader, "turbine", 0, 10)
+	sensors, err := reader.Sensors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sensors) != 2 || sensors[0].ID != 1 || sensors[1].ID != 2 {
+		t.Fatalf("expected boiler and turbine, got %+v", sensors)
+	}
+}
+
+func expectRange(t *testing.T, s store.Store, name string, min, max float64) {
+	t.Helper()
+
+	sensor, err := s.SensorByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensor.MinSafeValue != min || sensor.MaxSafeValue != max {
+		t.Fatalf("expected %s to be safe from %g to %g, got %+v", name, min, max, sensor)
+	}
+}
@@ -0,0 +1,39 @@
+package store_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/golang-distributed-application/src/powerplant/datamanager/migrate"
+	"github.com/golang-distributed-application/src/powerplant/store"
+	"github.com/golang-distributed-application/src/powerplant/store/storetest"
+)
+
+// PostgresDSNEnv names the database TestPostgres migrates and checks, it's skipped without one,
+// !!! not the database of a running plant
+const PostgresDSNEnv = "POWERPLANT_TEST_POSTGRES_DSN"
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv(PostgresDSNEnv)
+	if dsn == "" {
+		t.Skip(PostgresDSNEnv + " isn't set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := migrate.Up(db, -1); err != nil {
+		t.Fatalf("Failed to migrate the database: %s", err)
+	}
+
+	s, err := store.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	storetest.Run(t, s)
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadingQuery picks the readings of a sensor taken from From up to, but not including, To
+type ReadingQuery struct {
+	SensorID int
+	From     time.Time
+	To       time.Time
+	// a page of Readings, Aggregate ignores them, Limit 0 means no limit
+	Offset int
+	Limit  int
+}
+
+// Aggregation is how Aggregate folds the readings of a bucket into one value
+type Aggregation string
+
+const (
+	Avg  Aggregation = "avg"
+	Min  Aggregation = "min"
+	Max  Aggregation = "max"
+	Last Aggregation = "last"
+)
+
+// ParseAggregation returns the aggregation called s
+func ParseAggregation(s string) (Aggregation, error) {
+	switch agg := Aggregation(s); agg {
+	case Avg, Min, Max, Last:
+		return agg, nil
+	}
+
+	return "", fmt.Errorf("unknown aggregation '%s', expected %s, %s, %s or %s", s, Avg, Min, Max, Last)
+}
+
+// Bucket is what Aggregate makes of the readings taken from Start for step
+type Bucket struct {
+	Start time.Time
+	Value float64
+	Count int // how many readings went into it
+}
+
+// bucketOf returns the number of the bucket t falls into
+func bucketOf(t, from time.Time, step time.Duration) int64 {
+	return int64(t.Sub(from) / step)
+}
+
+// aggregate folds readings, which are oldest first, into buckets of step counted from from
+func aggregate(readings []Reading, from time.Time, step time.Duration, agg Aggregation) []Bucket {
+	buckets := []Bucket{}
+
+	current := int64(-1)
+	for _, r := range readings {
+		n := bucketOf(r.TakenOn, from, step)
+		if len(buckets) == 0 || n != current {
+			current = n
+			buckets = append(buckets, Bucket{
+				Start: from.Add(time.Duration(n) * step),
+				Value: r.Value,
+			})
+		}
+
+		b := &buckets[len(buckets)-1]
+		b.Count++
+
+		switch agg {
+		case Avg:
+			// running mean, so the sum can't overflow
+			b.Value += (r.Value - b.Value) / float64(b.Count)
+		case Min:
+			if r.Value < b.Value {
+				b.Value = r.Value
+			}
+		case Max:
+			if r.Value > b.Value {
+				b.Value = r.Value
+			}
+		case Last:
+			b.Value = r.Value
+		}
+	}
+
+	return buckets
+}
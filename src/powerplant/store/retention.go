@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Resolution is the length of the buckets of a rollup
+type Resolution string
+
+const (
+	Minute Resolution = "1m" // rolled up from the readings
+	Hour   Resolution = "1h" // rolled up from the minutes, so they outlive the readings
+)
+
+// Step is how long a bucket of r is
+func (r Resolution) Step() time.Duration {
+	switch r {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	}
+
+	panic(fmt.Sprintf("unknown resolution '%s'", string(r)))
+}
+
+/*
+RetentionStore is a store that can fold the readings into rollups (min, max, avg and count per bucket)
+and delete the readings nobody needs one by one anymore, see datamanager.Retention.
+
+Each resolution is rolled up to a point in time, a reading that's saved later with an older timestamp
+isn't rolled up anymore, so rolling up should wait for the readings that are late.
+*/
+type RetentionStore interface {
+	// RolledUpTo returns up to where the rollups of res are complete, the zero time if nothing is rolled up yet
+	RolledUpTo(res Resolution) (time.Time, error)
+	// RollUp rolls up what comes after RolledUpTo up to to, which has to be on a bucket boundary,
+	// it returns how many buckets it wrote. An hour is only rolled up from minutes that are.
+	RollUp(res Resolution, to time.Time) (int64, error)
+	// DeleteReadings deletes the readings of a sensor taken before before and returns how many there were
+	DeleteReadings(sensorID int, before time.Time) (int64, error)
+}
@@ -0,0 +1,135 @@
+// store keeps the plant's sensors and their readings, in Postgres or in files of its own
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// the backends Open knows
+const (
+	PostgresBackend = "postgres" // the source is a data source name, the tables come from datamanager/migrate
+	FileBackend     = "file"     // the source is a directory, see FileStore
+)
+
+var (
+	// ErrNotFound is returned (wrapped) for a sensor that isn't in the store
+	ErrNotFound = errors.New("not found")
+	// ErrRejected is returned (wrapped) when the store refuses the data itself, rather than being unavailable,
+	// trying again won't help
+	ErrRejected = errors.New("rejected")
+)
+
+type Sensor struct {
+	ID           int // given by the store
+	Name         string
+	SerialNo     string
+	UnitType     string
+	MinSafeValue float64
+	MaxSafeValue float64
+}
+
+type Reading struct {
+	SensorID int
+	Value    float64
+	TakenOn  time.Time
+}
+
+// SensorStore is what the plant knows about its sensors
+type SensorStore interface {
+	// SensorByName returns ErrNotFound for a sensor that isn't there
+	SensorByName(name string) (Sensor, error)
+	// Sensors returns all of the sensors, by id
+	Sensors() ([]Sensor, error)
+	// AddSensor adds a sensor unless there is one with its name already, the ID of s is ignored,
+	// created tells whether it was this call that added it
+	AddSensor(s Sensor) (created bool, err error)
+}
+
+// ReadingStore keeps the readings of the sensors
+type ReadingStore interface {
+	// SaveReadings saves all of the readings or none of them, a reading of a sensor that isn't in the store
+	// is rejected with ErrRejected
+	SaveReadings(readings []Reading) error
+	// Readings returns a page of the readings of a sensor, oldest first
+	Readings(q ReadingQuery) ([]Reading, error)
+	// Aggregate returns the readings of a sensor folded into buckets of step, oldest first,
+	// the buckets without readings are left out
+	Aggregate(q ReadingQuery, step time.Duration, agg Aggregation) ([]Bucket, error)
+}
+
+// AlarmTransition is a change of the alarm state of a sensor or a rule, see dto.AlarmTransition
+type AlarmTransition struct {
+	Sensor       string
+	Rule         string // empty for the safe range of Sensor
+	From         string
+	To           string
+	Value        float64
+	MinSafeValue float64
+	MaxSafeValue float64
+	TakenOn      time.Time // of the reading that made it change
+}
+
+// Alarm names the alarm the transition is of, like dto.AlarmTransition.Alarm
+func (t AlarmTransition) Alarm() string {
+	if t.Rule != "" {
+		return "rule." + t.Rule
+	}
+
+	return t.Sensor
+}
+
+// AlarmAction is what an operator did about an alarm, see dto.AlarmAction
+type AlarmAction struct {
+	Alarm        string
+	Kind         string // ack, shelve, unshelve or comment
+	Operator     string
+	Comment      string
+	ShelvedUntil time.Time // zero unless it's a shelve
+	TakenOn      time.Time
+}
+
+// AlarmQuery picks the transitions or actions taken from From up to To, of Alarm or of every alarm if it's empty
+type AlarmQuery struct {
+	Alarm string
+	From  time.Time
+	To    time.Time
+}
+
+// AlarmStore keeps the history of the alarms
+type AlarmStore interface {
+	// SaveAlarmTransition saves a transition unless it's there already, the same sensor and rule
+	// going to the same state at the same time is the same transition delivered twice
+	SaveAlarmTransition(t AlarmTransition) error
+	// AlarmTransitions returns the transitions picked by q, oldest first
+	AlarmTransitions(q AlarmQuery) ([]AlarmTransition, error)
+	// LatestRaises returns the latest transition out of Normal of every alarm there has been,
+	// what has happened to an alarm since is what's going on with it now
+	LatestRaises() ([]AlarmTransition, error)
+	// SaveAlarmAction saves an action unless it's there already, like SaveAlarmTransition
+	// the same operator doing the same to the same alarm at the same time is the same action
+	SaveAlarmAction(a AlarmAction) error
+	// AlarmActions returns the actions picked by q, oldest first
+	AlarmActions(q AlarmQuery) ([]AlarmAction, error)
+}
+
+type Store interface {
+	SensorStore
+	ReadingStore
+	AlarmStore
+
+	Close() error
+}
+
+// Open opens the store of the given backend, see the backend constants for what the source is
+func Open(backend, source string) (Store, error) {
+	switch backend {
+	case PostgresBackend:
+		return OpenPostgres(source)
+	case FileBackend:
+		return OpenFileStore(source)
+	}
+
+	return nil, fmt.Errorf("unknown store backend '%s', expected %s or %s", backend, PostgresBackend, FileBackend)
+}
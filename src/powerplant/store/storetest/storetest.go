@@ -0,0 +1,434 @@
+// storetest checks that a store backend behaves like the others, so the plant works the same on any of them
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// Run runs the conformance checks against s, each one a subtest of t, so one failing doesn't hide the others.
+// It adds sensors and readings of its own, with names nobody else uses, so s doesn't have to be empty,
+// but it's best not pointed at the database of a running plant.
+func Run(t *testing.T, s store.Store) {
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	checks := []struct {
+		name  string
+		check func(store.Store, string) error
+	}{
+		{"unknown sensor", testUnknownSensor},
+		{"add sensor", testAddSensor},
+		{"save readings", testSaveReadings},
+		{"reject readings", testRejectReadings},
+		{"query readings", testQueryReadings},
+		{"aggregate readings", testAggregateReadings},
+		{"alarm transitions", testAlarmTransitions},
+		{"alarm actions", testAlarmActions},
+	}
+
+	for _, c := range checks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.check(s, suffix); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func testUnknownSensor(s store.Store, suffix string) error {
+	_, err := s.SensorByName("unknown_" + suffix)
+	if !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	return nil
+}
+
+func testAddSensor(s store.Store, suffix string) error {
+	sensor := store.Sensor{
+		Name:         "boiler_pressure_" + suffix,
+		SerialNo:     "BP-1",
+		UnitType:     "MPa",
+		MinSafeValue: 1.5,
+		MaxSafeValue: 4.25,
+	}
+
+	created, err := s.AddSensor(sensor)
+	if err != nil || !created {
+		return fmt.Errorf("expected the sensor to be created, got %v, %v", created, err)
+	}
+
+	// the second time around nothing changes
+	changed := sensor
+	changed.SerialNo = "BP-2"
+	created, err = s.AddSensor(changed)
+	if err != nil || created {
+		return fmt.Errorf("expected the sensor to be there already, got %v, %v", created, err)
+	}
+
+	got, err := s.SensorByName(sensor.Name)
+	if err != nil {
+		return err
+	}
+
+	id := got.ID
+	if id <= 0 {
+		return fmt.Errorf("expected an id, got %d", id)
+	}
+	got.ID = 0
+	if got != sensor {
+		return fmt.Errorf("expected %+v, got %+v", sensor, got)
+	}
+
+	other, err := addSensor(s, "turbine_temp_"+suffix)
+	if err != nil {
+		return err
+	}
+	if other.ID == id {
+		return fmt.Errorf("expected two sensors to have different ids, both have %d", other.ID)
+	}
+
+	sensors, err := s.Sensors()
+	if err != nil {
+		return err
+	}
+	listed := 0
+	for i, listedSensor := range sensors {
+		if i > 0 && listedSensor.ID <= sensors[i-1].ID {
+			return fmt.Errorf("expected the sensors by id, got %d after %d", listedSensor.ID, sensors[i-1].ID)
+		}
+		if listedSensor.ID == id || listedSensor.ID == other.ID {
+			listed++
+		}
+	}
+	if listed != 2 {
+		return fmt.Errorf("expected both sensors among the %d sensors, found %d", len(sensors), listed)
+	}
+
+	return nil
+}
+
+func testSaveReadings(s store.Store, suffix string) error {
+	sensor, err := addSensor(s, "feedwater_flow_"+suffix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	readings := []store.Reading{}
+	for i := 0; i < 100; i++ {
+		readings = append(readings, store.Reading{
+			SensorID: sensor.ID,
+			Value:    float64(i) / 3,
+			TakenOn:  now.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+
+	if err := s.SaveReadings(readings); err != nil {
+		return err
+	}
+
+	// nothing to do is fine too
+	if err := s.SaveReadings(nil); err != nil {
+		return err
+	}
+
+	got, err := s.Readings(store.ReadingQuery{
+		SensorID: sensor.ID,
+		From:     now,
+		To:       now.Add(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	if len(got) != len(readings) {
+		return fmt.Errorf("expected %d readings, got %d", len(readings), len(got))
+	}
+	for i := range got {
+		if !sameReading(got[i], readings[i]) {
+			return fmt.Errorf("expected reading %d to be %+v, got %+v", i, readings[i], got[i])
+		}
+	}
+
+	return nil
+}
+
+func testRejectReadings(s store.Store, suffix string) error {
+	sensor, err := addSensor(s, "condenser_vacuum_"+suffix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	unknownID := math.MaxInt32 - 1
+	err = s.SaveReadings([]store.Reading{
+		{SensorID: sensor.ID, Value: 1, TakenOn: now},
+		{SensorID: unknownID, Value: 2, TakenOn: now},
+	})
+	if !errors.Is(err, store.ErrRejected) {
+		return fmt.Errorf("expected a reading of an unknown sensor to be rejected with ErrRejected, got %v", err)
+	}
+
+	// the good reading of the batch went with the bad one
+	got, err := s.Readings(store.ReadingQuery{
+		SensorID: sensor.ID,
+		From:     now.Add(-time.Minute),
+		To:       now.Add(time.Minute),
+	})
+	if err != nil {
+		return err
+	}
+	if len(got) != 0 {
+		return fmt.Errorf("expected a rejected batch to save nothing, got %+v", got)
+	}
+
+	return nil
+}
+
+func testQueryReadings(s store.Store, suffix string) error {
+	sensor, err := addSensor(s, "steam_temp_"+suffix)
+	if err != nil {
+		return err
+	}
+
+	// saved newest first, they come back oldest first
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	readings := []store.Reading{}
+	for i := 9; i >= 0; i-- {
+		readings = append(readings, store.Reading{
+			SensorID: sensor.ID,
+			Value:    float64(i),
+			TakenOn:  from.Add(time.Duration(i) * time.Second),
+		})
+	}
+	if err := s.SaveReadings(readings); err != nil {
+		return err
+	}
+
+	// To isn't included, so 8 and 9 are left out
+	q := store.ReadingQuery{
+		SensorID: sensor.ID,
+		From:     from,
+		To:       from.Add(8 * time.Second),
+		Limit:    3,
+	}
+
+	values := []float64{}
+	for {
+		page, err := s.Readings(q)
+		if err != nil {
+			return err
+		}
+		if len(page) > q.Limit {
+			return fmt.Errorf("expected at most %d readings, got %d", q.Limit, len(page))
+		}
+
+		for _, r := range page {
+			values = append(values, r.Value)
+		}
+
+		if len(page) < q.Limit {
+			break
+		}
+		q.Offset += len(page)
+	}
+
+	if fmt.Sprint(values) != "[0 1 2 3 4 5 6 7]" {
+		return fmt.Errorf("expected the readings 0 to 7 in order, got %v", values)
+	}
+
+	return nil
+}
+
+func testAggregateReadings(s store.Store, suffix string) error {
+	sensor, err := addSensor(s, "drum_level_"+suffix)
+	if err != nil {
+		return err
+	}
+
+	// 0 to 9 a second apart, the buckets 10s to 20s are empty, then 30
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	readings := []store.Reading{}
+	for i := 0; i < 10; i++ {
+		readings = append(readings, store.Reading{
+			SensorID: sensor.ID,
+			Value:    float64(i),
+			TakenOn:  from.Add(time.Duration(i) * time.Second),
+		})
+	}
+	readings = append(readings, store.Reading{SensorID: sensor.ID, Value: 30, TakenOn: from.Add(30 * time.Second)})
+	if err := s.SaveReadings(readings); err != nil {
+		return err
+	}
+
+	q := store.ReadingQuery{
+		SensorID: sensor.ID,
+		From:     from,
+		To:       from.Add(time.Minute),
+	}
+
+	expected := map[store.Aggregation]string{
+		store.Avg:  "[0s:2/5 5s:7/5 30s:30/1]",
+		store.Min:  "[0s:0/5 5s:5/5 30s:30/1]",
+		store.Max:  "[0s:4/5 5s:9/5 30s:30/1]",
+		store.Last: "[0s:4/5 5s:9/5 30s:30/1]",
+	}
+	for agg, want := range expected {
+		buckets, err := s.Aggregate(q, 5*time.Second, agg)
+		if err != nil {
+			return fmt.Errorf("%s: %s", agg, err)
+		}
+
+		got := []string{}
+		for _, b := range buckets {
+			got = append(got, fmt.Sprintf("%s:%g/%d", b.Start.Sub(from), b.Value, b.Count))
+		}
+		if fmt.Sprint(got) != want {
+			return fmt.Errorf("%s: expected the buckets %s, got %v", agg, want, got)
+		}
+	}
+
+	return nil
+}
+
+func testAlarmTransitions(s store.Store, suffix string) error {
+	from := time.Now().Truncate(time.Microsecond)
+	transitions := []store.AlarmTransition{
+		{Sensor: "boiler_pressure_" + suffix, From: "Normal", To: "Warning", Value: 4.1, MaxSafeValue: 4.25, TakenOn: from.Add(time.Second)},
+		{Sensor: "boiler_pressure_" + suffix, From: "Warning", To: "Alarm", Value: 4.3, MaxSafeValue: 4.25, TakenOn: from.Add(2 * time.Second)},
+		// the same reading firing a rule is another transition
+		{Sensor: "boiler_pressure_" + suffix, Rule: "overpressure_" + suffix, From: "Normal", To: "Alarm", Value: 4.3, TakenOn: from.Add(2 * time.Second)},
+	}
+
+	// saved the wrong way round, and the second one twice
+	for _, t := range []store.AlarmTransition{transitions[1], transitions[0], transitions[1], transitions[2]} {
+		if err := s.SaveAlarmTransition(t); err != nil {
+			return err
+		}
+	}
+
+	got, err := s.AlarmTransitions(store.AlarmQuery{From: from, To: from.Add(time.Minute)})
+	if err != nil {
+		return err
+	}
+
+	// other checks may have run against the store before
+	mine := []store.AlarmTransition{}
+	for _, t := range got {
+		if t.Sensor == transitions[0].Sensor {
+			mine = append(mine, t)
+		}
+	}
+
+	if len(mine) != len(transitions) {
+		return fmt.Errorf("expected %d transitions, got %+v", len(transitions), mine)
+	}
+	for i := range mine {
+		if !mine[i].TakenOn.Equal(transitions[i].TakenOn) {
+			return fmt.Errorf("expected transition %d to be %+v, got %+v", i, transitions[i], mine[i])
+		}
+		mine[i].TakenOn = transitions[i].TakenOn
+		if mine[i] != transitions[i] {
+			return fmt.Errorf("expected transition %d to be %+v, got %+v", i, transitions[i], mine[i])
+		}
+	}
+
+	// the rule is an alarm of its own
+	got, err = s.AlarmTransitions(store.AlarmQuery{Alarm: transitions[2].Alarm(), From: from, To: from.Add(time.Minute)})
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || got[0].Rule != transitions[2].Rule {
+		return fmt.Errorf("expected the transition of the rule, got %+v", got)
+	}
+
+	raises, err := s.LatestRaises()
+	if err != nil {
+		return err
+	}
+	want := map[string]time.Time{transitions[0].Alarm(): transitions[0].TakenOn, transitions[2].Alarm(): transitions[2].TakenOn}
+	for _, t := range raises {
+		if at, ok := want[t.Alarm()]; ok {
+			if !t.TakenOn.Equal(at) {
+				return fmt.Errorf("expected %s to be raised at %s, got %+v", t.Alarm(), at, t)
+			}
+			delete(want, t.Alarm())
+		}
+	}
+	if len(want) > 0 {
+		return fmt.Errorf("expected the latest raises of %v, got %+v", want, raises)
+	}
+
+	return nil
+}
+
+func testAlarmActions(s store.Store, suffix string) error {
+	from := time.Now().Truncate(time.Microsecond)
+	alarm := "boiler_pressure_" + suffix
+	actions := []store.AlarmAction{
+		{Alarm: alarm, Kind: "ack", Operator: "jane", TakenOn: from.Add(time.Second)},
+		{Alarm: alarm, Kind: "shelve", Operator: "joe", Comment: "under maintenance", ShelvedUntil: from.Add(time.Hour), TakenOn: from.Add(2 * time.Second)},
+		{Alarm: "rule.overpressure_" + suffix, Kind: "comment", Operator: "jane", Comment: "on it", TakenOn: from.Add(2 * time.Second)},
+	}
+
+	// saved the wrong way round, and the second one twice
+	for _, a := range []store.AlarmAction{actions[1], actions[0], actions[1], actions[2]} {
+		if err := s.SaveAlarmAction(a); err != nil {
+			return err
+		}
+	}
+
+	got, err := s.AlarmActions(store.AlarmQuery{Alarm: alarm, From: from, To: from.Add(time.Minute)})
+	if err != nil {
+		return err
+	}
+
+	if len(got) != 2 {
+		return fmt.Errorf("expected 2 actions, got %+v", got)
+	}
+	for i := range got {
+		if !got[i].TakenOn.Equal(actions[i].TakenOn) || !got[i].ShelvedUntil.Equal(actions[i].ShelvedUntil) {
+			return fmt.Errorf("expected action %d to be %+v, got %+v", i, actions[i], got[i])
+		}
+		got[i].TakenOn, got[i].ShelvedUntil = actions[i].TakenOn, actions[i].ShelvedUntil
+		if got[i] != actions[i] {
+			return fmt.Errorf("expected action %d to be %+v, got %+v", i, actions[i], got[i])
+		}
+	}
+
+	got, err = s.AlarmActions(store.AlarmQuery{From: from, To: from.Add(time.Minute)})
+	if err != nil {
+		return err
+	}
+	mine := 0
+	for _, a := range got {
+		if a.Alarm == actions[0].Alarm || a.Alarm == actions[2].Alarm {
+			mine++
+		}
+	}
+	if mine != len(actions) {
+		return fmt.Errorf("expected %d actions of every alarm, got %+v", len(actions), got)
+	}
+
+	return nil
+}
+
+// sameReading compares readings the way a store keeps them, to the microsecond
+func sameReading(a, b store.Reading) bool {
+	return a.SensorID == b.SensorID && a.Value == b.Value &&
+		a.TakenOn.Truncate(time.Microsecond).Equal(b.TakenOn.Truncate(time.Microsecond))
+}
+
+func addSensor(s store.Store, name string) (store.Sensor, error) {
+	if _, err := s.AddSensor(store.Sensor{Name: name, MaxSafeValue: 1}); err != nil {
+		return store.Sensor{}, err
+	}
+
+	return s.SensorByName(name)
+}
@@ -0,0 +1,66 @@
+// show an alarm of the board, send is how an action on it goes to the web application,
+// every browser gets the alarm again once the action has been taken
+function setAlarm(alarm, send) {
+  var table = $('#alarmContainer tbody');
+  var row = table.find('tr').filter(function() {
+    return $(this).data('alarm') == alarm.alarm;
+  });
+
+  if (alarm.cleared) {
+    row.remove();
+    if (table.find('tr').length == 0) {
+      $('#alarmContainer').hide();
+    }
+    return;
+  }
+
+  if (row.length == 0) {
+    row = $('<tr><td class="alarm-name"></td><td class="alarm-state"></td><td class="alarm-value"></td>' +
+      '<td class="alarm-raised"></td><td class="alarm-acked"></td><td class="alarm-shelved"></td>' +
+      '<td class="alarm-comments"></td><td class="alarm-actions">' +
+      '<button class="btn btn-xs btn-default alarm-ack">Ack</button> ' +
+      '<button class="btn btn-xs btn-default alarm-shelve">Shelve 1h</button> ' +
+      '<button class="btn btn-xs btn-default alarm-unshelve">Unshelve</button> ' +
+      '<button class="btn btn-xs btn-default alarm-comment">Comment</button></td></tr>');
+    row.data('alarm', alarm.alarm);
+
+    var act = function(type, data) {
+      data.alarm = row.data('alarm');
+      data.operator = $('#alarmOperator').val();
+      send(type, data);
+    };
+    row.find('.alarm-ack').click(function() { act('ack', {}); });
+    row.find('.alarm-shelve').click(function() { act('shelve', { duration: '1h' }); });
+    row.find('.alarm-unshelve').click(function() { act('unshelve', {}); });
+    row.find('.alarm-comment').click(function() {
+      var comment = window.prompt('Comment on ' + row.data('alarm'));
+      if (comment) {
+        act('comment', { comment: comment });
+      }
+    });
+
+    table.append(row);
+  }
+
+  var shelved = alarm.shelvedUntil && new Date(alarm.shelvedUntil) > new Date();
+  var cls = '';
+  if (!shelved && !alarm.acknowledged) {
+    cls = alarm.state == 'Alarm' ? 'danger' : alarm.state == 'Warning' ? 'warning' : 'info';
+  }
+  row.attr('class', cls);
+  row.find('.alarm-name').text(alarm.alarm);
+  row.find('.alarm-state').text(alarm.state);
+  row.find('.alarm-value').text(alarm.rule ? '' : alarm.value);
+  row.find('.alarm-raised').text(new Date(alarm.raisedAt).toLocaleString());
+  row.find('.alarm-acked').text(alarm.acknowledged ?
+    alarm.ackedBy + ', ' + new Date(alarm.ackedAt).toLocaleString() : '');
+  row.find('.alarm-shelved').text(shelved ?
+    new Date(alarm.shelvedUntil).toLocaleString() + ' by ' + alarm.shelvedBy : '');
+  row.find('.alarm-comments').text((alarm.comments || []).map(function(c) {
+    return c.operator + ': ' + c.comment;
+  }).join('; '));
+  row.find('.alarm-ack').toggle(!alarm.acknowledged);
+  row.find('.alarm-shelve').toggle(!shelved);
+  row.find('.alarm-unshelve').toggle(!!shelved);
+  $('#alarmContainer').show();
+}
@@ -53,6 +53,12 @@ function createChart(parentNode, data) {
           dataPoints: [],
           color: 'green',
           fillOpacity: 0.2
+      },
+      {
+          type: 'rangeArea',
+          dataPoints: [],
+          color: 'blue',
+          fillOpacity: 0.15
       }
     ]
   });
@@ -64,19 +70,59 @@ function createChart(parentNode, data) {
 }
 
 function updateChart(msg) {
-  var node = $('.' + msg.Name);
+  addPoint(msg.Name, new Date(msg.Timestamp), msg.Value);
+}
+
+// chart the mean of an aggregate of the coordinator at the end of its window, between its min and max
+function updateChartAggregate(a) {
+  addPoint(a.Sensor, new Date(a.End), a.Mean, [a.Min, a.Max]);
+}
+
+function addPoint(name, x, y, spread) {
+  var node = $('.' + name);
   if (node.length == 0) return;
   var chart = node.CanvasJSChart();
   var pts = chart.options.data[0].dataPoints;
   var range = chart.options.data[1].dataPoints;
+  var spreads = chart.options.data[2].dataPoints;
   var minSafeValue = parseFloat(node[0].dataset['minSafeValue']);
   var maxSafeValue = parseFloat(node[0].dataset['maxSafeValue']);
-  pts.push({x: new Date(msg.Timestamp),
-     y: msg.Value});
+  pts.push({x: x, y: y});
   while (pts.length > 20) {
     pts.shift();
   }
+  if (spread) {
+    spreads.push({x: x, y: spread});
+    while (spreads.length > 20) {
+      spreads.shift();
+    }
+  }
   range[0] = {x: pts[0].x, y: [minSafeValue, maxSafeValue]};
   range[1] = {x: pts[pts.length-1].x, y:[minSafeValue, maxSafeValue]};
   chart.render();
 }
+
+// grey out the chart of a sensor the coordinator hasn't heard from for a while
+function setChartLost(name, lost) {
+  var node = $('.' + name);
+  if (node.length == 0) return;
+  var panel = node.closest('.panel');
+  panel.css('opacity', lost ? 0.4 : 1);
+  panel.attr('title', lost ? 'no readings from ' + name : '');
+}
+
+// show where a sensor runs and how it's set up, from its latest heartbeat
+function setChartInfo(info) {
+  var node = $('.' + info.name);
+  if (node.length == 0) return;
+  var heading = node.closest('.panel').find('.panel-heading');
+  var text = info.host + ', version ' + info.version + ', ' + info.frequency + ' readings/s' +
+    ' between ' + info.min + ' and ' + info.max + ', up ' + Math.round(info.uptime) + 's';
+  var infoNode = heading.find('.sensor-info');
+  if (infoNode.length == 0) {
+    heading.append('<div class="col-sm-2 text-right">Sensor</div>' +
+      '<div class="col-sm-10 sensor-info"></div>');
+    infoNode = heading.find('.sensor-info');
+  }
+  infoNode.text(text);
+}
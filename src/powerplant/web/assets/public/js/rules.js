@@ -0,0 +1,27 @@
+// show the state of a rule of the coordinators, a firing rule is highlighted by its severity
+function setRule(rule) {
+  var table = $('#ruleContainer tbody');
+  var row = table.find('tr').filter(function() {
+    return $(this).data('rule') == rule.name;
+  });
+
+  if (rule.removed) {
+    row.remove();
+    return;
+  }
+
+  if (row.length == 0) {
+    row = $('<tr><td class="rule-name"></td><td class="rule-expression"></td>' +
+      '<td class="rule-state"></td><td class="rule-since"></td></tr>');
+    row.data('rule', rule.name);
+    table.append(row);
+  }
+
+  row.attr('class', rule.firing ? (rule.severity == 'Warning' ? 'warning' : 'danger') : '');
+  row.attr('title', rule.description || '');
+  row.find('.rule-name').text(rule.name);
+  row.find('.rule-expression').text(rule.expression);
+  row.find('.rule-state').text(rule.firing ? rule.state : 'Resolved');
+  row.find('.rule-since').text(rule.since ? new Date(rule.since).toLocaleString() : '');
+  $('#ruleContainer').show();
+}
@@ -17,6 +17,32 @@
       case "reading":
         updateChart(msg.data);
         break;
+      case "aggregate":
+        updateChartAggregate(msg.data);
+        break;
+      case "sourceLost":
+        setChartLost(msg.data.name, true);
+        break;
+      case "sourceRecovered":
+        setChartLost(msg.data.name, false);
+        break;
+      case "sourceInfo":
+        setChartInfo(msg.data);
+        break;
+      case "rule":
+        setRule(msg.data);
+        break;
+      case "alarm":
+        setAlarm(msg.data, function(type, data) {
+          socket.send(JSON.stringify({
+            type: type,
+            data: data
+          }));
+        });
+        break;
+      case "error":
+        console.log(msg.data);
+        break;
     }
   });
 
@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/dto"
+	"github.com/golang-distributed-application/src/powerplant/web/model"
+)
+
+// endOfTime is where the history of an alarm that's on the board ends
+var endOfTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+/*
+alarmBoard is what's going on with the alarms, built from the transitions the coordinators publish and the actions
+the operators take in any of the web applications, so every web application has the same board:
+1, an alarm is on the board from its raise until it's back to Normal and acknowledged, in whichever order
+2, an ack holds until the alarm gets more severe or is raised again, then it has to be acknowledged again
+3, a shelved alarm stays on the board, the browsers keep quiet about it until it's unshelved or ShelvedUntil
+
+A transition that isn't newer than the latest one of its alarm is left out, so it doesn't matter whether
+one comes from the broker or from the store first.
+*/
+type alarmBoard struct {
+	mutex  sync.Mutex
+	alarms map[string]*alarmInfo
+}
+
+// alarmInfo is how the browser gets an alarm
+type alarmInfo struct {
+	Alarm        string         `json:"alarm"`
+	Sensor       string         `json:"sensor,omitempty"` // the one whose reading changed it last
+	Rule         string         `json:"rule,omitempty"`
+	State        string         `json:"state"` // Normal once it's back, it stays on the board until it's acknowledged
+	Value        float64        `json:"value"`
+	Since        time.Time      `json:"since"` // of the latest transition
+	RaisedAt     time.Time      `json:"raisedAt"`
+	Acknowledged bool           `json:"acknowledged"`
+	AckedBy      string         `json:"ackedBy,omitempty"`
+	AckedAt      *time.Time     `json:"ackedAt,omitempty"`
+	ShelvedBy    string         `json:"shelvedBy,omitempty"`
+	ShelvedUntil *time.Time     `json:"shelvedUntil,omitempty"`
+	Comments     []alarmComment `json:"comments,omitempty"`
+	Cleared      bool           `json:"cleared,omitempty"` // it's gone off the board
+
+	// !!! the transitions go by the clocks of the sensors and the actions by the ones of the web applications,
+	// an ack within their difference of an escalation may count for it or not
+	ackFrom time.Time // the raise or the latest escalation, an ack taken before it doesn't count
+}
+
+type alarmComment struct {
+	Operator  string    `json:"operator"`
+	Comment   string    `json:"comment"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newAlarmBoard() *alarmBoard {
+	return &alarmBoard{alarms: make(map[string]*alarmInfo)}
+}
+
+// load puts the alarms the data manager has saved on the board, the ones still going on since their latest raise
+func (b *alarmBoard) load() error {
+	raises, err := model.GetLatestRaises()
+	if err != nil {
+		return err
+	}
+
+	for _, raise := range raises {
+		transitions, err := model.GetAlarmTransitions(raise.Alarm, raise.Timestamp, endOfTime)
+		if err != nil {
+			return err
+		}
+		actions, err := model.GetAlarmActions(raise.Alarm, raise.Timestamp, endOfTime)
+		if err != nil {
+			return err
+		}
+
+		// the transitions first, an ack is only held against the escalations before it by its timestamp
+		for _, t := range transitions {
+			b.transition(dto.AlarmTransition{
+				Sensor:    t.Sensor,
+				Rule:      t.Rule,
+				From:      dto.AlarmState(t.From),
+				To:        dto.AlarmState(t.To),
+				Value:     t.Value,
+				Timestamp: t.Timestamp,
+			})
+		}
+		for _, a := range actions {
+			action := dto.AlarmAction{
+				Alarm:     a.Alarm,
+				Kind:      dto.AlarmActionKind(a.Kind),
+				Operator:  a.Operator,
+				Comment:   a.Comment,
+				Timestamp: a.Timestamp,
+			}
+			if a.ShelvedUntil != nil {
+				action.ShelvedUntil = *a.ShelvedUntil
+			}
+			b.action(action)
+		}
+	}
+
+	return nil
+}
+
+// transition applies t and returns the alarm, false if it's left out
+func (b *alarmBoard) transition(t dto.AlarmTransition) (alarmInfo, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	name := t.Alarm()
+	a := b.alarms[name]
+	if a != nil && !t.Timestamp.After(a.Since) {
+		return alarmInfo{}, false
+	}
+	if a == nil {
+		if t.To == dto.StateNormal {
+			// it has been acknowledged and cleared already
+			return alarmInfo{}, false
+		}
+		a = &alarmInfo{Alarm: name, Rule: t.Rule, RaisedAt: t.Timestamp}
+		b.alarms[name] = a
+	}
+
+	if t.From == dto.StateNormal || t.To.Severity() > dto.AlarmState(a.State).Severity() {
+		a.Acknowledged, a.AckedBy, a.AckedAt = false, "", nil
+		a.ackFrom = t.Timestamp
+		if t.From == dto.StateNormal {
+			a.RaisedAt = t.Timestamp
+		}
+	}
+	a.Sensor = t.Sensor
+	a.State = string(t.To)
+	a.Value = t.Value
+	a.Since = t.Timestamp
+
+	return b.settle(a), true
+}
+
+// action applies an action of an operator and returns the alarm, false if it isn't on the board
+func (b *alarmBoard) action(act dto.AlarmAction) (alarmInfo, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	a := b.alarms[act.Alarm]
+	if a == nil {
+		return alarmInfo{}, false
+	}
+
+	if act.Comment != "" && !a.hasComment(act) {
+		a.Comments = append(a.Comments, alarmComment{Operator: act.Operator, Comment: act.Comment, Timestamp: act.Timestamp})
+	}
+
+	switch act.Kind {
+	case dto.AckAction:
+		if !act.Timestamp.Before(a.ackFrom) {
+			at := act.Timestamp
+			a.Acknowledged, a.AckedBy, a.AckedAt = true, act.Operator, &at
+		}
+	case dto.ShelveAction:
+		until := act.ShelvedUntil
+		a.ShelvedBy, a.ShelvedUntil = act.Operator, &until
+	case dto.UnshelveAction:
+		a.ShelvedBy, a.ShelvedUntil = "", nil
+	}
+
+	return b.settle(a), true
+}
+
+// hasComment tells whether the comment of act is there already, an action delivered twice
+func (a *alarmInfo) hasComment(act dto.AlarmAction) bool {
+	for _, c := range a.Comments {
+		if c.Operator == act.Operator && c.Timestamp.Equal(act.Timestamp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// settle takes a off the board once it's back to Normal and acknowledged, and returns a copy of it
+func (b *alarmBoard) settle(a *alarmInfo) alarmInfo {
+	if a.State == string(dto.StateNormal) && a.Acknowledged {
+		delete(b.alarms, a.Alarm)
+		a.Cleared = true
+	}
+
+	info := *a
+	info.Comments = append([]alarmComment(nil), a.Comments...)
+	return info
+}
+
+// has tells whether alarm is on the board
+func (b *alarmBoard) has(alarm string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_, ok := b.alarms[alarm]
+	return ok
+}
+
+// infos returns the alarms on the board, the latest raised first
+func (b *alarmBoard) infos() []alarmInfo {
+	b.mutex.Lock()
+	infos := make([]alarmInfo, 0, len(b.alarms))
+	for _, a := range b.alarms {
+		info := *a
+		info.Comments = append([]alarmComment(nil), a.Comments...)
+		infos = append(infos, info)
+	}
+	b.mutex.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].RaisedAt.Equal(infos[j].RaisedAt) {
+			return infos[i].RaisedAt.After(infos[j].RaisedAt)
+		}
+		return infos[i].Alarm < infos[j].Alarm
+	})
+	return infos
+}
+
+// alarmRequest is the data of the ack, shelve, unshelve and comment messages of the browsers
+type alarmRequest struct {
+	Alarm    string `json:"alarm"`
+	Operator string `json:"operator"` // the address of the browser if it's left out
+	Comment  string `json:"comment"`
+	Duration string `json:"duration"` // how long to shelve for, like 30m
+}
+
+// newAlarmAction checks what a browser asks of an alarm, operator is who it is if it doesn't say
+func (b *alarmBoard) newAlarmAction(kind dto.AlarmActionKind, data json.RawMessage, operator string) (dto.AlarmAction, error) {
+	req := alarmRequest{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return dto.AlarmAction{}, fmt.Errorf("%s: %s", kind, err)
+	}
+	if !b.has(req.Alarm) {
+		return dto.AlarmAction{}, fmt.Errorf("%s: alarm '%s' isn't on the board", kind, req.Alarm)
+	}
+
+	a := dto.AlarmAction{
+		Alarm:     req.Alarm,
+		Kind:      kind,
+		Operator:  strings.TrimSpace(req.Operator),
+		Comment:   strings.TrimSpace(req.Comment),
+		Timestamp: time.Now(),
+	}
+	if a.Operator == "" {
+		a.Operator = operator
+	}
+
+	switch kind {
+	case dto.ShelveAction:
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			return dto.AlarmAction{}, fmt.Errorf("%s: duration has to be positive, like 30m", kind)
+		}
+		a.ShelvedUntil = a.Timestamp.Add(d)
+	case dto.CommentAction:
+		if a.Comment == "" {
+			return dto.AlarmAction{}, errors.New("comment: the comment is empty")
+		}
+	}
+
+	return a, nil
+}
+
+type alarmHistory struct {
+	Alarm       string                  `json:"alarm,omitempty"`
+	From        time.Time               `json:"from"`
+	To          time.Time               `json:"to"`
+	Transitions []model.AlarmTransition `json:"transitions"`
+	Actions     []model.AlarmAction     `json:"actions"`
+}
+
+/*
+handleAlarms answers with the alarms:
+
+	GET /api/alarms           the ones on the board, the latest raised first
+	GET /api/alarms/history   the transitions and the actions the data manager has saved, oldest first
+
+The history takes from and to like the readings, and alarm for the history of one of them, a sensor or
+"rule." and the name of a rule. The browsers get every change of the board as an "alarm" message over the websocket.
+*/
+func handleAlarms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result interface{}
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/api/alarms":
+		result = webSocket.board.infos()
+
+	case "/api/alarms/history":
+		query := r.URL.Query()
+		from, to, err := timeRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		history := alarmHistory{Alarm: query.Get("alarm"), From: from, To: to}
+		history.Transitions, err = model.GetAlarmTransitions(history.Alarm, from, to)
+		if err == nil {
+			history.Actions, err = model.GetAlarmActions(history.Alarm, from, to)
+		}
+		if err != nil {
+			log.Printf("Failed to query the alarm history. Error: %s", err)
+			http.Error(w, "failed to query the alarm history", http.StatusInternalServerError)
+			return
+		}
+		result = history
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
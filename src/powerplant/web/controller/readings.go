@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+	"github.com/golang-distributed-application/src/powerplant/web/model"
+)
+
+const (
+	defaultRange    = time.Hour // from defaults to this long before to, to to now
+	defaultPageSize = 500
+	maxPageSize     = 5000
+	maxBuckets      = 10000 // step has to be at least (to - from) / maxBuckets
+)
+
+type readingsPage struct {
+	Sensor   string          `json:"sensor"`
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Readings []model.Reading `json:"readings"`
+	Next     string          `json:"next,omitempty"` // the url of the next page, if there is one
+}
+
+type aggregatedReadings struct {
+	Sensor  string         `json:"sensor"`
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Step    string         `json:"step"`
+	Agg     string         `json:"agg"`
+	Buckets []model.Bucket `json:"buckets"`
+}
+
+/*
+handleReadings answers GET /api/sensors/{name}/readings with the readings the data manager has saved.
+
+	from, to      RFC 3339 times, the last hour by default, to isn't included
+	offset, limit a page of the readings, 500 by default, next in the answer is the url of the next page
+	step          a duration (10s, 5m, 1h), folds the readings into buckets of step counted from from
+	agg           how a bucket is folded: avg (the default), min, max or last
+*/
+func handleReadings(w http.ResponseWriter, r *http.Request) {
+	name, ok := readingsSensor(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	from, to, err := timeRange(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	if query.Get("step") != "" || query.Get("agg") != "" {
+		result, err = aggregated(name, from, to, query)
+	} else {
+		result, err = page(name, from, to, r.URL)
+	}
+
+	var badRequest badRequestError
+	switch {
+	case errors.As(err, &badRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, model.ErrSensorNotFound):
+		http.Error(w, fmt.Sprintf("sensor '%s' not found", name), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to query the readings of %s. Error: %s", name, err)
+		http.Error(w, "failed to query the readings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keeps the & of next as it is
+	enc.Encode(result)
+}
+
+// badRequestError is a query parameter that doesn't make sense
+type badRequestError struct {
+	error
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return badRequestError{fmt.Errorf(format, args...)}
+}
+
+// readingsSensor returns the name of the sensor in /api/sensors/{name}/readings
+func readingsSensor(path string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/sensors/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "readings" {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+func timeRange(query url.Values) (time.Time, time.Time, error) {
+	to := time.Now()
+	if s := query.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return to, to, badRequest("to: %s", err)
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultRange)
+	if s := query.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return from, to, badRequest("from: %s", err)
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return from, to, badRequest("from has to be before to")
+	}
+
+	return from, to, nil
+}
+
+func page(name string, from, to time.Time, u *url.URL) (readingsPage, error) {
+	query := u.Query()
+
+	offset, err := intParam(query, "offset", 0)
+	if err != nil {
+		return readingsPage{}, err
+	}
+	limit, err := intParam(query, "limit", defaultPageSize)
+	if err != nil {
+		return readingsPage{}, err
+	}
+	if limit < 1 || limit > maxPageSize {
+		return readingsPage{}, badRequest("limit has to be from 1 to %d", maxPageSize)
+	}
+
+	// one more tells whether there is a next page
+	readings, err := model.GetReadings(name, from, to, offset, limit+1)
+	if err != nil {
+		return readingsPage{}, err
+	}
+
+	result := readingsPage{
+		Sensor:   name,
+		From:     from,
+		To:       to,
+		Readings: readings,
+	}
+
+	if len(readings) > limit {
+		result.Readings = readings[:limit]
+
+		// !!! pins from and to, so the pages don't shift while the sensor keeps sending
+		query.Set("from", from.Format(time.RFC3339Nano))
+		query.Set("to", to.Format(time.RFC3339Nano))
+		query.Set("offset", strconv.Itoa(offset+limit))
+		query.Set("limit", strconv.Itoa(limit))
+		next := *u
+		next.RawQuery = query.Encode()
+		result.Next = next.RequestURI()
+	}
+
+	return result, nil
+}
+
+func aggregated(name string, from, to time.Time, query url.Values) (aggregatedReadings, error) {
+	if query.Get("step") == "" {
+		return aggregatedReadings{}, badRequest("agg needs a step")
+	}
+	step, err := time.ParseDuration(query.Get("step"))
+	if err != nil {
+		return aggregatedReadings{}, badRequest("step: %s", err)
+	}
+	if step <= 0 || to.Sub(from)/step > maxBuckets {
+		return aggregatedReadings{}, badRequest("step has to be at least %s for this range", to.Sub(from)/maxBuckets)
+	}
+
+	agg := store.Avg
+	if query.Get("agg") != "" {
+		agg, err = store.ParseAggregation(query.Get("agg"))
+		if err != nil {
+			return aggregatedReadings{}, badRequest("%s", err)
+		}
+	}
+
+	buckets, err := model.GetAggregates(name, from, to, step, agg)
+	if err != nil {
+		return aggregatedReadings{}, err
+	}
+
+	return aggregatedReadings{
+		Sensor:  name,
+		From:    from,
+		To:      to,
+		Step:    step.String(),
+		Agg:     string(agg),
+		Buckets: buckets,
+	}, nil
+}
+
+func intParam(query url.Values, key string, fallback int) (int, error) {
+	s := query.Get(key)
+	if s == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, badRequest("%s has to be a number, at least 0", key)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+handleRules answers with the state of the rules of the coordinators, as they have told the web application:
+
+	GET /api/rules          all of them, sorted by name, firing=true leaves out the resolved ones
+	GET /api/rules/{name}   one of them
+
+The browsers get every change as a "rule" message over the websocket.
+*/
+func handleRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	infos := webSocket.ruleInfos()
+
+	var result interface{}
+	if name := strings.TrimPrefix(r.URL.Path, "/api/rules/"); name != r.URL.Path && name != "" {
+		for _, info := range infos {
+			if info.Name == name {
+				result = info
+			}
+		}
+		if result == nil {
+			http.Error(w, fmt.Sprintf("rule '%s' not found", name), http.StatusNotFound)
+			return
+		}
+	} else {
+		firing := false
+		if s := r.URL.Query().Get("firing"); s != "" {
+			var err error
+			if firing, err = strconv.ParseBool(s); err != nil {
+				http.Error(w, fmt.Sprintf("firing: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		selected := []ruleInfo{}
+		for _, info := range infos {
+			if info.Firing || !firing {
+				selected = append(selected, info)
+			}
+		}
+		result = selected
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // the conditions are full of < and >
+	enc.Encode(result)
+}
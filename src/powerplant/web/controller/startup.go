@@ -1,21 +1,46 @@
 package controller
 
-import "net/http"
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
 
-var webSocket = newWebsocketController()
+	"github.com/golang-distributed-application/src/powerplant/config"
+)
+
+var webSocket *websocketController
+
+// Initialize registers the handlers, the browsers get the messages from the coordinators until ctx is done
+func Initialize(ctx context.Context, cfg *config.Config) {
+	webSocket = newWebsocketController(ctx, cfg.AMQP.URL, cfg.Web.Window, cfg.Encoding.Alarms, cfg.Datamanager.RetryDelay)
 
-func Initialize() {
 	registerRoutes()
-	registerFileServers()
+	registerFileServers(cfg.Web.AssetsDir)
+}
+
+// Close closes the websockets and the broker once the ctx given to Initialize is done,
+// http.Server.Shutdown leaves the websockets alone since they have been hijacked
+func Close() {
+	webSocket.close()
 }
 
 func registerRoutes() {
 	http.HandleFunc("/ws", webSocket.handleMessage)
+	http.HandleFunc("/api/sensors/", handleReadings)
+	http.HandleFunc("/api/rules", handleRules)
+	http.HandleFunc("/api/rules/", handleRules)
+	http.HandleFunc("/api/alarms", handleAlarms)
+	http.HandleFunc("/api/alarms/", handleAlarms)
 }
 
-func registerFileServers() {
+func registerFileServers(assetsDir string) {
+	if _, err := os.Stat(assetsDir); err != nil {
+		log.Fatalf("Web assets not found, check web.assetsDir: %s", err)
+	}
+
 	http.Handle("/public/",
-		http.FileServer(http.Dir("/Users/ziwang/Uber/sync/ziwangdev1.dev.uber.com/home/uber/gocode/src/github.com/golang-distributed-application/src/powerplant/web/assets")))
+		http.FileServer(http.Dir(assetsDir)))
 
 	// Need to move files in /public/lib/ to node_modules
 	// http.Handle("/public/lib/",
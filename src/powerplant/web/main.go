@@ -1,13 +1,56 @@
 package main
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/golang-distributed-application/src/powerplant/config"
 	"github.com/golang-distributed-application/src/powerplant/web/controller"
+	"github.com/golang-distributed-application/src/powerplant/web/model"
 )
 
 func main() {
-	controller.Initialize()
+	cfg := config.MustLoad()
 
-	http.ListenAndServe(":3000", nil)
+	// runs until it's interrupted or terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s, err := cfg.OpenStore()
+	if err != nil {
+		log.Fatalf("Failed to open the store: %s", err)
+	}
+	model.Open(s)
+	defer model.Close()
+
+	controller.Initialize(ctx, cfg)
+
+	server := &http.Server{Addr: cfg.Web.Addr}
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	controller.Close()
+
+	if err != nil {
+		log.Fatalf("Web application stopped: %s", err)
+	}
+
+	log.Println("Web application stopped")
 }
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// AlarmTransition is a change of the state of an alarm, as the data manager has saved it
+type AlarmTransition struct {
+	Alarm        string    `json:"alarm"`
+	Sensor       string    `json:"sensor,omitempty"` // the one whose reading did it, empty when a rule was reloaded
+	Rule         string    `json:"rule,omitempty"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Value        float64   `json:"value"`
+	MinSafeValue float64   `json:"minSafeValue"`
+	MaxSafeValue float64   `json:"maxSafeValue"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// AlarmAction is what an operator did about an alarm, as the data manager has saved it
+type AlarmAction struct {
+	Alarm        string     `json:"alarm"`
+	Kind         string     `json:"kind"`
+	Operator     string     `json:"operator"`
+	Comment      string     `json:"comment,omitempty"`
+	ShelvedUntil *time.Time `json:"shelvedUntil,omitempty"`
+	Timestamp    time.Time  `json:"timestamp"`
+}
+
+// GetAlarmTransitions returns the transitions of alarm, or of every alarm if it's empty, taken from from up to to,
+// oldest first
+func GetAlarmTransitions(alarm string, from, to time.Time) ([]AlarmTransition, error) {
+	transitions, err := db.AlarmTransitions(store.AlarmQuery{Alarm: alarm, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	return newAlarmTransitions(transitions), nil
+}
+
+// GetLatestRaises returns the latest transition out of Normal of every alarm there has been
+func GetLatestRaises() ([]AlarmTransition, error) {
+	transitions, err := db.LatestRaises()
+	if err != nil {
+		return nil, err
+	}
+
+	return newAlarmTransitions(transitions), nil
+}
+
+// GetAlarmActions returns the actions on alarm, or on every alarm if it's empty, taken from from up to to,
+// oldest first
+func GetAlarmActions(alarm string, from, to time.Time) ([]AlarmAction, error) {
+	actions, err := db.AlarmActions(store.AlarmQuery{Alarm: alarm, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]AlarmAction, len(actions))
+	for i, a := range actions {
+		result[i] = AlarmAction{
+			Alarm:     a.Alarm,
+			Kind:      a.Kind,
+			Operator:  a.Operator,
+			Comment:   a.Comment,
+			Timestamp: a.TakenOn,
+		}
+		if !a.ShelvedUntil.IsZero() {
+			until := a.ShelvedUntil
+			result[i].ShelvedUntil = &until
+		}
+	}
+
+	return result, nil
+}
+
+func newAlarmTransitions(transitions []store.AlarmTransition) []AlarmTransition {
+	result := make([]AlarmTransition, len(transitions))
+	for i, t := range transitions {
+		result[i] = AlarmTransition{
+			Alarm:        t.Alarm(),
+			Sensor:       t.Sensor,
+			Rule:         t.Rule,
+			From:         t.From,
+			To:           t.To,
+			Value:        t.Value,
+			MinSafeValue: t.MinSafeValue,
+			MaxSafeValue: t.MaxSafeValue,
+			Timestamp:    t.TakenOn,
+		}
+	}
+
+	return result
+}
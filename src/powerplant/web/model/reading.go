@@ -0,0 +1,81 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+type Reading struct {
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bucket is a value for the readings taken from Start for a step
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Value float64   `json:"value"`
+	Count int       `json:"count"`
+}
+
+// GetReadings returns the readings of a sensor taken from from up to to, oldest first,
+// skipping offset of them and returning at most limit
+func GetReadings(name string, from, to time.Time, offset, limit int) ([]Reading, error) {
+	id, err := sensorID(name)
+	if err != nil {
+		return nil, err
+	}
+
+	readings, err := db.Readings(store.ReadingQuery{
+		SensorID: id,
+		From:     from,
+		To:       to,
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Reading, len(readings))
+	for i, r := range readings {
+		result[i] = Reading{Value: r.Value, Timestamp: r.TakenOn}
+	}
+
+	return result, nil
+}
+
+// GetAggregates returns the readings of a sensor taken from from up to to folded by agg into buckets of step,
+// the buckets without readings are left out
+func GetAggregates(name string, from, to time.Time, step time.Duration, agg store.Aggregation) ([]Bucket, error) {
+	id, err := sensorID(name)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := db.Aggregate(store.ReadingQuery{
+		SensorID: id,
+		From:     from,
+		To:       to,
+	}, step, agg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Bucket, len(buckets))
+	for i, b := range buckets {
+		result[i] = Bucket(b)
+	}
+
+	return result, nil
+}
+
+func sensorID(name string) (int, error) {
+	s, err := db.SensorByName(name)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, ErrSensorNotFound
+	}
+
+	return s.ID, err
+}
@@ -1,5 +1,14 @@
 package model
 
+import (
+	"errors"
+
+	"github.com/golang-distributed-application/src/powerplant/store"
+)
+
+// ErrSensorNotFound is returned for a sensor that isn't in the sensor table (yet)
+var ErrSensorNotFound = errors.New("sensor not found")
+
 type Sensor struct {
 	Name         string  `json:"name"`
 	SerialNo     string  `json:"serialNo"`
@@ -9,16 +18,19 @@ type Sensor struct {
 }
 
 func GetSensorByName(name string) (Sensor, error) {
-	q := `SELECT name, serial_no, unit_type,
-          min_safe_value, max_safe_value
-        FROM sensor
-        WHERE name = $1`
-
-	result := Sensor{}
-
-	row := db.QueryRow(q, name)
-	err := row.Scan(&result.Name, &result.SerialNo, &result.UnitType,
-		&result.MinSafeValue, &result.MaxSafeValue)
+	s, err := db.SensorByName(name)
+	if errors.Is(err, store.ErrNotFound) {
+		return Sensor{}, ErrSensorNotFound
+	}
+	if err != nil {
+		return Sensor{}, err
+	}
 
-	return result, err
+	return Sensor{
+		Name:         s.Name,
+		SerialNo:     s.SerialNo,
+		UnitType:     s.UnitType,
+		MinSafeValue: s.MinSafeValue,
+		MaxSafeValue: s.MaxSafeValue,
+	}, nil
 }